@@ -3,6 +3,8 @@ package astafx
 import (
 	"go.uber.org/fx"
 
+	"github.com/astaclinic/astafx/configfx"
+	"github.com/astaclinic/astafx/healthfx"
 	"github.com/astaclinic/astafx/httpfx"
 	"github.com/astaclinic/astafx/infofx"
 	"github.com/astaclinic/astafx/loggerfx"
@@ -12,6 +14,8 @@ import (
 )
 
 var Module = fx.Options(
+	configfx.Module,
+	healthfx.Module,
 	httpfx.Module,
 	infofx.Module,
 	loggerfx.Module,
@@ -1,9 +1,12 @@
 package config
 
 import (
+	"errors"
+	"os"
 	"path"
 	"runtime/debug"
 	"strings"
+	"sync"
 
 	"github.com/adrg/xdg"
 	"github.com/spf13/viper"
@@ -11,39 +14,200 @@ import (
 	"github.com/astaclinic/astafx/logger"
 )
 
-func InitConfig(cfgFile string) {
+// Options controls where and how the config is loaded.
+type Options struct {
+	// File is the config file to read, the default paths are searched if empty
+	File string
+	// Profile selects the <name>.<profile>.yaml overlay next to the config
+	// file, it defaults to the value of the ASTA_PROFILE environment variable
+	Profile string
+	// Strict reports the config keys which none of the sections consumes
+	Strict StrictMode
+	// EnvPrefix is the prefix of the environment variables overriding config
+	// values, e.g. with prefix ASTA the variable ASTA_HTTP_LISTEN_ADDR overrides
+	// http.listen_addr, it defaults to the package name
+	EnvPrefix string
+}
+
+// Source is a loaded config, holding the merged settings of the defaults of
+// its sections, the config files and the environment. Every application
+// loads its own source with the sections of its modules, so applications with
+// different configs can run side by side in one process.
+type Source struct {
+	opts     Options
+	sections []Section
+
+	mu      sync.RWMutex
+	viper   *viper.Viper
+	files   []string
+	origins map[string]string
+}
+
+// Load reads the config described by opts into a new source, with the
+// defaults of the given sections. A config file missing from the default
+// paths is not an error, the source then holds the defaults and the
+// environment only.
+func Load(opts Options, sections ...Section) (*Source, error) {
+	s := &Source{opts: opts, sections: sortSections(sections)}
+	v := viper.New()
+	files, origins, err := s.read(v)
+	if err != nil {
+		return nil, err
+	}
+	s.viper, s.files, s.origins = v, files, origins
+	return s, nil
+}
+
+// Viper returns the current settings. The returned instance is replaced, not
+// modified, when the source is reloaded.
+func (s *Source) Viper() *viper.Viper {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.viper
+}
+
+// Sections returns the sections of the source ordered by key.
+func (s *Source) Sections() []Section {
+	return append([]Section{}, s.sections...)
+}
+
+// Files returns the config files read, the base file followed by its overlays.
+func (s *Source) Files() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string{}, s.files...)
+}
+
+// Reload reads the config again into a new viper instance, which replaces the
+// current one only if check accepts it.
+func (s *Source) Reload(check func(v *viper.Viper) error) error {
+	v := viper.New()
+	files, origins, err := s.read(v)
+	if err != nil {
+		return err
+	}
+	if err := check(v); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.viper, s.files, s.origins = v, files, origins
+	return nil
+}
+
+// read applies the defaults of the sections to v and reads the config files and
+// the environment into it.
+func (s *Source) read(v *viper.Viper) ([]string, map[string]string, error) {
 	packageName := GetPackageName()
-	logger.Infof("Loading config for package %s", packageName)
 
-	viper.SetConfigType("yaml")
+	// support reading from environmental variables
+	// all env variables are capitalized and prefixed, dot (levels) and dashes are replaced with underscores
+	v.SetEnvPrefix(s.envPrefix())
+	v.SetEnvKeyReplacer(envKeyReplacer)
+	v.AutomaticEnv()
 
-	if cfgFile != "" {
-		viper.SetConfigFile(cfgFile)
-		logger.Infof("Loading config from %s", cfgFile)
+	for _, section := range s.sections {
+		for _, field := range section.Fields() {
+			if field.Value != nil {
+				v.SetDefault(field.Key, field.Value)
+			}
+			// bind every value explicitly, viper only looks up keys it
+			// already knows of in the environment otherwise
+			if err := v.BindEnv(field.Key); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	v.SetConfigType("yaml")
+
+	if s.opts.File != "" {
+		v.SetConfigFile(s.opts.File)
 	} else {
-		viper.SetConfigName("config")
+		v.SetConfigName("config")
 
-		viper.AddConfigPath(path.Join("/etc", packageName))
+		v.AddConfigPath(path.Join("/etc", packageName))
 		for _, configDir := range xdg.ConfigDirs {
-			viper.AddConfigPath(path.Join(configDir, packageName))
+			v.AddConfigPath(path.Join(configDir, packageName))
 		}
-		viper.AddConfigPath(path.Join(xdg.ConfigHome, packageName))
-		viper.AddConfigPath("./config")
-		logger.Infof("Searching config from default paths")
+		v.AddConfigPath(path.Join(xdg.ConfigHome, packageName))
+		v.AddConfigPath("./config")
 	}
 
-	// support reading from environmental variables
-	// all env variables are capitalized, dot (levels) and dashes are replaced with underscores
-	viper.AutomaticEnv()
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	files, origins, err := readLayers(v, s.profile())
+	var notFound viper.ConfigFileNotFoundError
+	if errors.As(err, &notFound) {
+		logger.Warnf("No config file found for package %s, using defaults and env variables", packageName)
+		files, origins = nil, map[string]string{}
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if err := checkStrict(s.opts.Strict, s.envPrefix(), s.sections, origins); err != nil {
+		return nil, nil, err
+	}
+	return files, origins, nil
+}
+
+func (s *Source) envPrefix() string {
+	if s.opts.EnvPrefix != "" {
+		return s.opts.EnvPrefix
+	}
+	return GetPackageName()
+}
+
+// EnvName returns the environment variable overriding the value of key.
+func (s *Source) EnvName(key string) string {
+	return envKeyReplacer.Replace(strings.ToUpper(s.envPrefix() + "_" + key))
+}
+
+func (s *Source) profile() string {
+	if s.opts.Profile != "" {
+		return s.opts.Profile
+	}
+	return os.Getenv(ProfileEnv)
+}
 
-	err := viper.ReadInConfig()
+var (
+	globalMu      sync.Mutex
+	globalOptions Options
+	globalSource  *Source
+)
+
+// InitConfig reads the config into the global viper instance.
+//
+// Deprecated: the config is loaded by configfx.Module for every application,
+// supply config.Options to the application to read a specific file. The
+// options given here are used by configfx.Module if none are supplied.
+func InitConfig(cfgFile string) {
+	logger.Infof("Loading config for package %s", GetPackageName())
+	if cfgFile != "" {
+		logger.Infof("Loading config from %s", cfgFile)
+	} else {
+		logger.Infof("Searching config from default paths")
+	}
+
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	globalOptions = Options{File: cfgFile}
+	source := &Source{opts: globalOptions, viper: viper.GetViper()}
+	files, origins, err := source.read(source.viper)
+	source.files, source.origins = files, origins
+	globalSource = source
 
 	if err != nil {
 		logger.Warnf("Error in reading config. %v", err)
 	}
 }
 
+// DefaultOptions returns the options given to InitConfig, if it was called.
+func DefaultOptions() Options {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	return globalOptions
+}
+
+var envKeyReplacer = strings.NewReplacer(".", "_", "-", "_")
+
 func GetPackageName() string {
 	buildInfo, ok := debug.ReadBuildInfo()
 	packageName := "asta"
@@ -1,7 +1,10 @@
 package config_test
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/spf13/viper"
@@ -34,13 +37,168 @@ foo:
 		}
 	})
 	t.Run("Test env variable input", func(t *testing.T) {
-		t.Setenv("FOO_BAR", "123")
-		viper.SetDefault("foo.bar", "default_value")
-		config.InitConfig("")
+		t.Setenv("ASTA_FOO_BAR", "123")
+		source, err := config.Load(config.Options{EnvPrefix: "asta"})
+		if err != nil {
+			t.Fatalf("failed to load config: %v", err)
+		}
 		expectedVal := "123"
-		gotVal := viper.Get("foo.bar")
+		gotVal := source.Viper().Get("foo.bar")
 		if gotVal != expectedVal {
 			t.Errorf("unexpected config value, got %s, expected %s", gotVal, expectedVal)
 		}
 	})
 }
+
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yaml": `
+foo:
+  bar: base
+  baz: base
+  qux: base
+`,
+		"config.dev.yaml": `
+foo:
+  bar: dev
+  baz: dev
+`,
+		"conf.d/10-first.yaml": `
+foo:
+  baz: first
+`,
+		"conf.d/20-second.yaml": `
+foo:
+  baz: second
+`,
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+			t.Fatalf("failed to create config dir: %v", err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write config file %s: %v", file, err)
+		}
+	}
+	t.Setenv(config.ProfileEnv, "dev")
+	source, err := config.Load(config.Options{File: filepath.Join(dir, "config.yaml")})
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	expected := map[string]struct {
+		val    string
+		origin string
+	}{
+		"foo.bar": {"dev", filepath.Join(dir, "config.dev.yaml")},
+		"foo.baz": {"second", filepath.Join(dir, "conf.d/20-second.yaml")},
+		"foo.qux": {"base", filepath.Join(dir, "config.yaml")},
+	}
+	for key, want := range expected {
+		if got := source.Viper().GetString(key); got != want.val {
+			t.Errorf("unexpected config value of %s, got %s, expected %s", key, got, want.val)
+		}
+		if got := source.Origin(key); got != want.origin {
+			t.Errorf("unexpected origin of %s, got %s, expected %s", key, got, want.origin)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	type registerConfig struct {
+		Host     string `mapstructure:"host" validate:"required"`
+		Password string `mapstructure:"password" secret:"true"`
+		Pool     struct {
+			MaxConns int `mapstructure:"max_conns"`
+		} `mapstructure:"pool"`
+	}
+	defaults := registerConfig{Host: "localhost"}
+	defaults.Pool.MaxConns = 10
+	section := config.NewSection("register", defaults)
+
+	t.Run("Test defaults", func(t *testing.T) {
+		source, err := config.Load(config.Options{}, section)
+		if err != nil {
+			t.Fatalf("failed to load config: %v", err)
+		}
+		if got := source.Viper().GetString("register.host"); got != "localhost" {
+			t.Errorf("unexpected default value, got %s, expected localhost", got)
+		}
+		if got := source.Viper().GetInt("register.pool.max_conns"); got != 10 {
+			t.Errorf("unexpected default value, got %d, expected 10", got)
+		}
+	})
+	t.Run("Test env binding", func(t *testing.T) {
+		t.Setenv("ASTA_REGISTER_PASSWORD", "hunter2")
+		t.Setenv("REGISTER_HOST", "unprefixed")
+		source, err := config.Load(config.Options{EnvPrefix: "asta"}, section)
+		if err != nil {
+			t.Fatalf("failed to load config: %v", err)
+		}
+		settings := source.Viper().AllSettings()["register"].(map[string]any)
+		if settings["password"] != "hunter2" {
+			t.Errorf("unexpected config value, got %v, expected hunter2", settings["password"])
+		}
+		if settings["host"] != "localhost" {
+			t.Errorf("unprefixed env variable read, got %v, expected localhost", settings["host"])
+		}
+	})
+	t.Run("Test strict mode", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "config.yaml")
+		content := []byte(`
+register:
+  hostname: localhost
+  pool:
+    max_conns: 20
+`)
+		if err := os.WriteFile(file, content, 0o644); err != nil {
+			t.Fatalf("failed to write config file: %v", err)
+		}
+		t.Setenv("ASTA_REGISTER_PASSWROD", "hunter2")
+		if _, err := config.Load(config.Options{File: file, Strict: config.StrictWarn, EnvPrefix: "asta"}, section); err != nil {
+			t.Errorf("unexpected error in warn mode: %v", err)
+		}
+		_, err := config.Load(config.Options{File: file, Strict: config.StrictFail, EnvPrefix: "asta"}, section)
+		var unknownKeysError *config.UnknownKeysError
+		if !errors.As(err, &unknownKeysError) {
+			t.Fatalf("expected unknown keys error, got %v", err)
+		}
+		expected := []string{
+			"env variable ASTA_REGISTER_PASSWROD",
+			"register.hostname in " + file,
+		}
+		if !reflect.DeepEqual(unknownKeysError.Keys, expected) {
+			t.Errorf("unexpected unknown keys, got %v, expected %v", unknownKeysError.Keys, expected)
+		}
+	})
+	t.Run("Test secret redaction", func(t *testing.T) {
+		validate, err := config.NewValidate()
+		if err != nil {
+			t.Fatalf("failed to create validator: %v", err)
+		}
+		decoder, err := config.NewDecoder(validate)
+		if err != nil {
+			t.Fatalf("failed to create decoder: %v", err)
+		}
+		settings := decoder.RedactSecrets([]config.Section{section}, map[string]any{
+			"register": map[string]any{"host": "localhost", "password": "hunter2"},
+		})["register"].(map[string]any)
+		if settings["password"] != config.Redacted || settings["host"] != "localhost" {
+			t.Errorf("unexpected redacted settings, got %v", settings)
+		}
+	})
+	t.Run("Test JSON schema", func(t *testing.T) {
+		properties := config.JSONSchema([]config.Section{section})["properties"].(map[string]any)
+		schema, ok := properties["register"].(map[string]any)
+		if !ok {
+			t.Fatalf("registered section missing from schema")
+		}
+		pool := schema["properties"].(map[string]any)["pool"].(map[string]any)
+		maxConns := pool["properties"].(map[string]any)["max_conns"].(map[string]any)
+		if maxConns["type"] != "integer" || maxConns["default"] != 10 {
+			t.Errorf("unexpected schema of register.pool.max_conns, got %v", maxConns)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/viper"
+
+	"github.com/astaclinic/astafx/logger"
+)
+
+// ProfileEnv is the environment variable selecting the config profile when
+// Options.Profile is empty.
+const ProfileEnv = "ASTA_PROFILE"
+
+// readLayers reads the config file into v followed by its overlays, which are
+// merged on top of it in order:
+//
+//  1. <name>.<profile>.yaml next to the config file, if a profile is selected
+//  2. conf.d/*.yaml next to the config file, in lexical order
+//
+// It returns the files read and the file setting the final value of every key.
+func readLayers(v *viper.Viper, profile string) ([]string, map[string]string, error) {
+	if err := v.ReadInConfig(); err != nil {
+		return nil, nil, err
+	}
+	base := v.ConfigFileUsed()
+	overlays, err := overlayFiles(base, profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := append([]string{base}, overlays...)
+
+	origins := map[string]string{}
+	for i, file := range files {
+		layer := viper.New()
+		layer.SetConfigType("yaml")
+		layer.SetConfigFile(file)
+		if err := layer.ReadInConfig(); err != nil {
+			return nil, nil, fmt.Errorf("error in reading config overlay %s: %w", file, err)
+		}
+		// the base file is already read into v
+		if i > 0 {
+			if err := v.MergeConfigMap(layer.AllSettings()); err != nil {
+				return nil, nil, fmt.Errorf("error in merging config overlay %s: %w", file, err)
+			}
+		}
+		for _, key := range layer.AllKeys() {
+			origins[key] = file
+		}
+	}
+	return files, origins, nil
+}
+
+// overlayFiles returns the files to be merged on top of the base config file.
+func overlayFiles(base, profile string) ([]string, error) {
+	dir := filepath.Dir(base)
+	var files []string
+	if profile != "" {
+		ext := filepath.Ext(base)
+		name := strings.TrimSuffix(filepath.Base(base), ext)
+		file := filepath.Join(dir, fmt.Sprintf("%s.%s%s", name, profile, ext))
+		_, err := os.Stat(file)
+		switch {
+		case err == nil:
+			files = append(files, file)
+		case errors.Is(err, fs.ErrNotExist):
+			logger.Warnf("Config overlay for profile %s not found at %s", profile, file)
+		default:
+			return nil, err
+		}
+	}
+	// Glob returns the matches in lexical order
+	dropIns, err := filepath.Glob(filepath.Join(dir, "conf.d", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	return append(files, dropIns...), nil
+}
+
+// Origin returns where the final value of key comes from, which is either the
+// config file setting it, "env" or "default".
+func (s *Source) Origin(key string) string {
+	key = strings.ToLower(key)
+	if _, ok := os.LookupEnv(s.EnvName(key)); ok {
+		return "env"
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if file, ok := s.origins[key]; ok {
+		return file
+	}
+	return "default"
+}
+
+// DumpOrigins writes every config key with the origin of its final value,
+// for debugging which of the layered config files is in effect.
+func (s *Source) DumpOrigins(w io.Writer) error {
+	keys := s.Viper().AllKeys()
+	sort.Strings(keys)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\n", key, s.Origin(key)); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// Origin is like Source.Origin for the config read by InitConfig.
+//
+// Deprecated: use Source.Origin.
+func Origin(key string) string {
+	return global().Origin(key)
+}
+
+// DumpOrigins is like Source.DumpOrigins for the config read by InitConfig.
+//
+// Deprecated: use Source.DumpOrigins.
+func DumpOrigins(w io.Writer) error {
+	return global().DumpOrigins(w)
+}
+
+func global() *Source {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	if globalSource == nil {
+		return &Source{viper: viper.GetViper()}
+	}
+	return globalSource
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Section describes a config struct consumed by a module and the key it is
+// read from.
+type Section struct {
+	Key  string
+	Type reflect.Type
+	// Defaults is a value of Type holding the default values of the section
+	Defaults any
+}
+
+// Field describes a single value of a config section.
+type Field struct {
+	// Key is the full dotted config key of the value, e.g. postgres.host
+	Key   string
+	Type  reflect.Type
+	Tag   reflect.StructTag
+	Value any
+	// Secret is set for fields tagged with `secret:"true"`, whose value is
+	// redacted when printing the config
+	Secret bool
+}
+
+// NewSection returns the section of the config struct T read from key along
+// with its default values.
+func NewSection[T any](key string, defaults T) Section {
+	return Section{
+		Key:      key,
+		Type:     reflect.TypeOf(defaults),
+		Defaults: defaults,
+	}
+}
+
+// sortSections returns the sections ordered by key. A section with the same
+// key as a previous one replaces it.
+func sortSections(sections []Section) []Section {
+	byKey := make(map[string]Section, len(sections))
+	for _, section := range sections {
+		byKey[section.Key] = section
+	}
+	sorted := make([]Section, 0, len(byKey))
+	for _, section := range byKey {
+		sorted = append(sorted, section)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
+
+// Validation is a custom validation tag available to the config structs.
+type Validation struct {
+	Tag  string
+	Func validator.Func
+}
+
+// NewValidate returns a validator with the given custom validations.
+func NewValidate(validations ...Validation) (*validator.Validate, error) {
+	validate := validator.New()
+	for _, validation := range validations {
+		if err := validate.RegisterValidation(validation.Tag, validation.Func); err != nil {
+			return nil, err
+		}
+	}
+	return validate, nil
+}
+
+// Fields returns every leaf value of the section with its default value.
+func (s Section) Fields() []Field {
+	return appendFields(nil, s.Key, s.Type, reflect.ValueOf(s.Defaults))
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// isLeaf reports whether values of t are config values rather than a nested
+// config struct.
+func isLeaf(t reflect.Type) bool {
+	t = indirectType(t)
+	return t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{})
+}
+
+func appendFields(fields []Field, prefix string, t reflect.Type, value reflect.Value) []Field {
+	t = indirectType(t)
+	for value.IsValid() && value.Kind() == reflect.Pointer {
+		value = value.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		if !structField.IsExported() {
+			continue
+		}
+		name, squash := fieldKey(structField)
+		key := prefix
+		if !squash {
+			key = joinKey(prefix, name)
+		}
+		var fieldValue reflect.Value
+		if value.IsValid() {
+			fieldValue = value.Field(i)
+		}
+		if !isLeaf(structField.Type) {
+			fields = appendFields(fields, key, structField.Type, fieldValue)
+			continue
+		}
+		field := Field{
+			Key:    key,
+			Type:   structField.Type,
+			Tag:    structField.Tag,
+			Secret: structField.Tag.Get("secret") == "true",
+		}
+		if fieldValue.IsValid() && !isNil(fieldValue) {
+			field.Value = fieldValue.Interface()
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// fieldKey returns the config key name of a struct field, following its
+// mapstructure tag, and whether the field is squashed into its parent.
+func fieldKey(field reflect.StructField) (string, bool) {
+	tag := strings.Split(field.Tag.Get("mapstructure"), ",")
+	name := tag[0]
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, len(tag) > 1 && tag[1] == "squash"
+}
+
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func isNil(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+		return value.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// JSONSchema returns a JSON Schema describing the config file accepted by the
+// sections, for editor completion and validation.
+func JSONSchema(sections []Section) map[string]any {
+	root := map[string]any{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"type":       "object",
+		"properties": map[string]any{},
+	}
+	for _, section := range sortSections(sections) {
+		schema := schemaOf(section.Type, reflect.ValueOf(section.Defaults))
+		// sections with a nested key such as "db.primary" are placed under
+		// the intermediate objects
+		parent := root
+		parts := strings.Split(section.Key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			properties := parent["properties"].(map[string]any)
+			child, ok := properties[part].(map[string]any)
+			if !ok {
+				child = map[string]any{"type": "object", "properties": map[string]any{}}
+				properties[part] = child
+			}
+			parent = child
+		}
+		parent["properties"].(map[string]any)[parts[len(parts)-1]] = schema
+	}
+	return root
+}
+
+func schemaOf(t reflect.Type, defaults reflect.Value) map[string]any {
+	t = indirectType(t)
+	for defaults.IsValid() && defaults.Kind() == reflect.Pointer {
+		defaults = defaults.Elem()
+	}
+	schema := map[string]any{}
+	switch {
+	case t == durationType:
+		// durations are written as strings like "1s" or as nanoseconds
+		schema["type"] = []string{"string", "integer"}
+		if defaults.IsValid() {
+			schema["default"] = defaults.Interface().(interface{ String() string }).String()
+		}
+		return schema
+	case !isLeaf(t):
+		properties := map[string]any{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			var fieldDefaults reflect.Value
+			if defaults.IsValid() {
+				fieldDefaults = defaults.Field(i)
+			}
+			fieldSchema := schemaOf(field.Type, fieldDefaults)
+			name, squash := fieldKey(field)
+			if squash {
+				for key, value := range fieldSchema["properties"].(map[string]any) {
+					properties[key] = value
+				}
+				continue
+			}
+			applyValidateTag(fieldSchema, field.Tag.Get("validate"))
+			properties[name] = fieldSchema
+			// values with a default do not need to be set in the config file
+			hasDefault := fieldDefaults.IsValid() && !fieldDefaults.IsZero()
+			if hasValidation(field.Tag.Get("validate"), "required") && !hasDefault {
+				required = append(required, name)
+			}
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	switch t.Kind() {
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		schema["type"] = "number"
+	case reflect.Slice, reflect.Array:
+		schema["type"] = "array"
+		schema["items"] = schemaOf(t.Elem(), reflect.Value{})
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = schemaOf(t.Elem(), reflect.Value{})
+	}
+	if defaults.IsValid() && !defaults.IsZero() {
+		schema["default"] = defaults.Interface()
+	}
+	return schema
+}
+
+// applyValidateTag translates the validations expressible in JSON Schema.
+func applyValidateTag(schema map[string]any, tag string) {
+	for _, validation := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(validation, "=")
+		switch name {
+		case "oneof":
+			schema["enum"] = strings.Fields(param)
+		case "uri", "url":
+			schema["format"] = "uri"
+		case "email":
+			schema["format"] = "email"
+		case "hostname":
+			schema["format"] = "hostname"
+		}
+	}
+}
+
+func hasValidation(tag, name string) bool {
+	for _, validation := range strings.Split(tag, ",") {
+		if validation == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// SecretResolver resolves config values referencing a secret stored
+// elsewhere, such as file:///run/secrets/pg_password or env://PG_PASS.
+type SecretResolver interface {
+	// Scheme returns the reference scheme handled by the resolver, e.g. "file"
+	Scheme() string
+	// Resolve returns the secret for the reference with the "<scheme>://"
+	// prefix removed.
+	Resolve(ref string) (string, error)
+}
+
+// FileSecretResolver resolves file:// references to the content of the file,
+// without the trailing line break.
+type FileSecretResolver struct{}
+
+func (FileSecretResolver) Scheme() string {
+	return "file"
+}
+
+func (FileSecretResolver) Resolve(ref string) (string, error) {
+	content, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// EnvSecretResolver resolves env:// references to the value of the
+// environment variable.
+type EnvSecretResolver struct{}
+
+func (EnvSecretResolver) Scheme() string {
+	return "env"
+}
+
+func (EnvSecretResolver) Resolve(ref string) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", ref)
+	}
+	return value, nil
+}
+
+// secretDecodeHook replaces string values referencing a secret with a
+// registered scheme by the resolved secret. Values with other schemes, like
+// a postgres:// DSN, are left untouched.
+func secretDecodeHook(resolvers map[string]SecretResolver) mapstructure.DecodeHookFuncType {
+	return func(_ reflect.Type, _ reflect.Type, data any) (any, error) {
+		value, ok := data.(string)
+		if !ok {
+			return data, nil
+		}
+		scheme, ref, ok := strings.Cut(value, "://")
+		if !ok {
+			return data, nil
+		}
+		resolver, ok := resolvers[scheme]
+		if !ok {
+			return data, nil
+		}
+		secret, err := resolver.Resolve(ref)
+		if err != nil {
+			return nil, fmt.Errorf("error in resolving secret %s: %w", value, err)
+		}
+		return secret, nil
+	}
+}
+
+// Redacted is the placeholder replacing secret values when printing the config.
+const Redacted = "<redacted>"
+
+// IsSecretReference reports whether value references a secret with a scheme
+// known to the decoder.
+func (d *Decoder) IsSecretReference(value string) bool {
+	scheme, _, ok := strings.Cut(value, "://")
+	if !ok {
+		return false
+	}
+	_, ok = d.resolvers[scheme]
+	return ok
+}
+
+// RedactSecrets replaces the values of every field of the sections tagged
+// with `secret:"true"` in settings, as returned by viper.AllSettings, unless
+// the value is a secret reference which is safe to show.
+func (d *Decoder) RedactSecrets(sections []Section, settings map[string]any) map[string]any {
+	for _, section := range sections {
+		for _, field := range section.Fields() {
+			if !field.Secret {
+				continue
+			}
+			parts := strings.Split(field.Key, ".")
+			parent := settings
+			for _, part := range parts[:len(parts)-1] {
+				child, ok := parent[part].(map[string]any)
+				if !ok {
+					parent = nil
+					break
+				}
+				parent = child
+			}
+			last := parts[len(parts)-1]
+			if parent == nil || parent[last] == nil || parent[last] == "" {
+				continue
+			}
+			if value, ok := parent[last].(string); ok && d.IsSecretReference(value) {
+				continue
+			}
+			parent[last] = Redacted
+		}
+	}
+	return settings
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/astaclinic/astafx/logger"
+)
+
+// StrictMode controls how config keys which none of the sections consumes
+// are reported, which usually are typos like postgres.hostname.
+type StrictMode string
+
+var (
+	// StrictOff ignores unknown keys
+	StrictOff StrictMode = ""
+	// StrictWarn logs a warning for every unknown key
+	StrictWarn StrictMode = "warn"
+	// StrictFail fails loading the config if there is any unknown key
+	StrictFail StrictMode = "fail"
+)
+
+// UnknownKeysError lists the config keys which none of the sections consumes.
+type UnknownKeysError struct {
+	Keys []string
+}
+
+func (e *UnknownKeysError) Error() string {
+	var b strings.Builder
+	b.WriteString("unknown config keys:")
+	for _, key := range e.Keys {
+		fmt.Fprintf(&b, "\n  %s", key)
+	}
+	return b.String()
+}
+
+// checkStrict reports the keys set in the config files, as recorded in
+// origins, or in environment variables with the env prefix that none of the
+// sections consumes.
+func checkStrict(mode StrictMode, envPrefix string, sections []Section, origins map[string]string) error {
+	if mode == StrictOff {
+		return nil
+	}
+	var fields []Field
+	for _, section := range sections {
+		fields = append(fields, section.Fields()...)
+	}
+
+	var unknown []string
+	for key, file := range origins {
+		if !consumed(fields, key) {
+			unknown = append(unknown, fmt.Sprintf("%s in %s", key, file))
+		}
+	}
+
+	envPrefix = envKeyReplacer.Replace(strings.ToUpper(envPrefix + "_"))
+	envNames := map[string]bool{ProfileEnv: true}
+	for _, field := range fields {
+		envNames[envPrefix+envKeyReplacer.Replace(strings.ToUpper(field.Key))] = true
+	}
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if strings.HasPrefix(name, envPrefix) && !envNames[name] {
+			unknown = append(unknown, fmt.Sprintf("env variable %s", name))
+		}
+	}
+
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	if mode == StrictWarn {
+		for _, key := range unknown {
+			logger.Warnf("Unknown config key %s", key)
+		}
+		return nil
+	}
+	return &UnknownKeysError{Keys: unknown}
+}
+
+// consumed reports whether key is read into any of the fields. Keys below a
+// map or slice field, such as postgres.params.search_path, are consumed by
+// the field.
+func consumed(fields []Field, key string) bool {
+	for _, field := range fields {
+		if key == field.Key {
+			return true
+		}
+		switch indirectType(field.Type).Kind() {
+		case reflect.Map, reflect.Slice, reflect.Array, reflect.Interface:
+			if strings.HasPrefix(key, field.Key+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// FieldError describes a single config value that failed validation.
+type FieldError struct {
+	// Path is the dotted config key of the value, e.g. postgres.host
+	Path  string
+	Tag   string
+	Param string
+}
+
+func (e FieldError) String() string {
+	tag := e.Tag
+	if e.Param != "" {
+		tag = fmt.Sprintf("%s=%s", e.Tag, e.Param)
+	}
+	return fmt.Sprintf("%s: failed on the '%s' validation", e.Path, tag)
+}
+
+// ValidationError lists every invalid value found in a config section.
+type ValidationError struct {
+	Key    string
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid config %q:", e.Key)
+	for _, field := range e.Fields {
+		fmt.Fprintf(&b, "\n  %s", field)
+	}
+	return b.String()
+}
+
+// Decoder decodes config sections into structs, resolving secret references
+// on the way, and validates them.
+type Decoder struct {
+	validate  *validator.Validate
+	resolvers map[string]SecretResolver
+}
+
+// NewDecoder returns a decoder resolving file:// and env:// secret
+// references in addition to the references handled by resolvers.
+func NewDecoder(validate *validator.Validate, resolvers ...SecretResolver) (*Decoder, error) {
+	d := &Decoder{
+		validate:  validate,
+		resolvers: map[string]SecretResolver{},
+	}
+	builtins := []SecretResolver{FileSecretResolver{}, EnvSecretResolver{}}
+	for _, resolver := range append(builtins, resolvers...) {
+		if _, ok := d.resolvers[resolver.Scheme()]; ok {
+			return nil, fmt.Errorf("duplicate secret resolver for scheme %s", resolver.Scheme())
+		}
+		d.resolvers[resolver.Scheme()] = resolver
+	}
+	return d, nil
+}
+
+// Unmarshal decodes the config subtree at key into out, which must be a
+// pointer to a struct, and validates it against its `validate` struct tags.
+func (d *Decoder) Unmarshal(v *viper.Viper, key string, out any) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           out,
+		WeaklyTypedInput: true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			secretDecodeHook(d.resolvers),
+			// the default hooks of viper
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+	})
+	if err != nil {
+		return err
+	}
+	if err := decoder.Decode(subtree(v, key)); err != nil {
+		return fmt.Errorf("error in decoding config %q: %w", key, err)
+	}
+	err = d.validate.Struct(out)
+	if err == nil {
+		return nil
+	}
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return fmt.Errorf("error in validating config %q: %w", key, err)
+	}
+	result := &ValidationError{Key: key}
+	for _, fieldError := range validationErrors {
+		result.Fields = append(result.Fields, FieldError{
+			Path:  fieldPath(key, reflect.TypeOf(out), fieldError.StructNamespace()),
+			Tag:   fieldError.Tag(),
+			Param: fieldError.Param(),
+		})
+	}
+	return result
+}
+
+// subtree returns the settings below key. Unlike v.Get(key), which returns
+// the map of the first source having key, the settings of every source are
+// merged, so a section partially set in the config file still gets the
+// defaults of its other values.
+func subtree(v *viper.Viper, key string) any {
+	var value any = v.AllSettings()
+	if key == "" {
+		return value
+	}
+	for _, part := range strings.Split(strings.ToLower(key), ".") {
+		settings, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = settings[part]
+	}
+	return value
+}
+
+// fieldPath converts a validator struct namespace such as
+// PostgresConfig.UserName into the config key postgres.user_name by
+// following the mapstructure tags of the struct fields.
+func fieldPath(key string, t reflect.Type, structNamespace string) string {
+	var path []string
+	if key != "" {
+		path = append(path, key)
+	}
+	// the first segment of the namespace is the name of the top level struct
+	segments := strings.Split(structNamespace, ".")[1:]
+	for _, segment := range segments {
+		name, index := segment, ""
+		if i := strings.IndexByte(segment, '['); i >= 0 {
+			name, index = segment[:i], segment[i:]
+		}
+		t = indirectType(t)
+		keyName := strings.ToLower(name)
+		if t != nil && t.Kind() == reflect.Struct {
+			if field, ok := t.FieldByName(name); ok {
+				var squash bool
+				keyName, squash = fieldKey(field)
+				t = field.Type
+				for i := strings.Count(index, "["); i > 0; i-- {
+					t = indirectType(t).Elem()
+				}
+				if squash {
+					continue
+				}
+			} else {
+				t = nil
+			}
+		}
+		path = append(path, keyName+index)
+	}
+	return strings.Join(path, ".")
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce collapses the burst of events written by editors and by
+// kubernetes ConfigMap updates into a single change.
+const watchDebounce = 100 * time.Millisecond
+
+// Watch calls onChange whenever a file changes in the directories of the
+// config files, including the conf.d directory, until the returned stop
+// function is called. Errors of the underlying watcher are passed to onError.
+//
+// Whole directories are watched to pick up atomic saves and symlink swaps.
+func (s *Source) Watch(onChange func(), onError func(error)) (func() error, error) {
+	files := s.Files()
+	if len(files) == 0 {
+		return func() error { return nil }, nil
+	}
+	dirs := map[string]bool{}
+	for _, file := range files {
+		dirs[filepath.Dir(file)] = true
+	}
+	dropInDir := filepath.Join(filepath.Dir(files[0]), "conf.d")
+	if info, err := os.Stat(dropInDir); err == nil && info.IsDir() {
+		dirs[dropInDir] = true
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var timer *time.Timer
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(watchDebounce, onChange)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				onError(err)
+			}
+		}
+	}()
+	return func() error {
+		err := watcher.Close()
+		<-done
+		return err
+	}, nil
+}
@@ -0,0 +1,168 @@
+package configcmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/fx"
+	"gopkg.in/yaml.v3"
+
+	"github.com/astaclinic/astafx/config"
+	"github.com/astaclinic/astafx/logger"
+)
+
+// appConfig is what the command needs of the app, the config sections,
+// validations and secret resolvers contributed by its modules.
+type appConfig struct {
+	fx.In
+	Options         config.Options          `optional:"true"`
+	Sections        []config.Section        `group:"configSections"`
+	Validations     []config.Validation     `group:"configValidations"`
+	SecretResolvers []config.SecretResolver `group:"secretResolvers"`
+}
+
+// errCollected stops the app after appConfig is collected.
+var errCollected = errors.New("config collected")
+
+// collect returns the appConfig of the app built from opts. It is collected
+// by the first invoke of the app, so the constructors and invokes of the
+// modules, which fail on an invalid config, are not run.
+func collect(opts []fx.Option) (appConfig, error) {
+	var collected appConfig
+	app := fx.New(
+		// fx runs the invokes of the modules first, in order
+		fx.Module("configcmd", fx.Invoke(func(p appConfig) error {
+			collected = p
+			return errCollected
+		})),
+		fx.Options(opts...),
+		// replaces the logger of loggerfx, which needs a valid config
+		fx.NopLogger,
+	)
+	if err := app.Err(); !errors.Is(err, errCollected) {
+		return collected, err
+	}
+	return collected, nil
+}
+
+// New returns the "config" command with the validate, print and schema
+// subcommands, to be mounted on the root command of a binary. The options
+// are those of the app, e.g. astafx.Module and the modules of the binary,
+// whose config sections, validations and secret resolvers are known to the
+// command; the app is not started.
+func New(opts ...fx.Option) *cobra.Command {
+	var profile, envPrefix string
+	var strict bool
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect and validate the config",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// keep the output of the subcommands free of log lines
+			logger.Output = cmd.ErrOrStderr()
+		},
+	}
+	cmd.PersistentFlags().StringVar(&profile, "profile", "", "config profile to apply, defaults to $"+config.ProfileEnv)
+	cmd.PersistentFlags().StringVar(&envPrefix, "env-prefix", "", "prefix of the env variables overriding config values, defaults to the package name")
+	cmd.PersistentFlags().BoolVar(&strict, "strict", false, "fail on config keys which no module consumes")
+
+	load := func(args []string) (*config.Source, *config.Decoder, error) {
+		app, err := collect(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		loadOptions := app.Options
+		if reflect.ValueOf(loadOptions).IsZero() {
+			loadOptions = config.DefaultOptions()
+		}
+		if profile != "" {
+			loadOptions.Profile = profile
+		}
+		if envPrefix != "" {
+			loadOptions.EnvPrefix = envPrefix
+		}
+		if strict {
+			loadOptions.Strict = config.StrictFail
+		}
+		if len(args) > 0 {
+			loadOptions.File = args[0]
+		}
+		source, err := config.Load(loadOptions, app.Sections...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error in reading config: %w", err)
+		}
+		validate, err := config.NewValidate(app.Validations...)
+		if err != nil {
+			return nil, nil, err
+		}
+		decoder, err := config.NewDecoder(validate, app.SecretResolvers...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return source, decoder, nil
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:          "validate [file]",
+			Short:        "Validate the config without starting the application",
+			Args:         cobra.MaximumNArgs(1),
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				source, decoder, err := load(args)
+				if err != nil {
+					return err
+				}
+				valid := true
+				for _, section := range source.Sections() {
+					out := reflect.New(section.Type).Interface()
+					if err := decoder.Unmarshal(source.Viper(), section.Key, out); err != nil {
+						fmt.Fprintln(cmd.ErrOrStderr(), err)
+						valid = false
+					}
+				}
+				if !valid {
+					return errors.New("config is invalid")
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "config %s is valid\n", strings.Join(source.Files(), ", "))
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:          "print [file]",
+			Short:        "Print the effective config with secrets redacted",
+			Args:         cobra.MaximumNArgs(1),
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				source, decoder, err := load(args)
+				if err != nil {
+					return err
+				}
+				encoder := yaml.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent(2)
+				if err := encoder.Encode(decoder.RedactSecrets(source.Sections(), source.Viper().AllSettings())); err != nil {
+					return err
+				}
+				return encoder.Close()
+			},
+		},
+		&cobra.Command{
+			Use:   "schema",
+			Short: "Print the JSON Schema of the config file",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				app, err := collect(opts)
+				if err != nil {
+					return err
+				}
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(config.JSONSchema(app.Sections))
+			},
+		},
+	)
+	return cmd
+}
@@ -0,0 +1,117 @@
+package configfx
+
+import (
+	"reflect"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+
+	"github.com/astaclinic/astafx/config"
+)
+
+// Module loads the config of the application into its own config.Source.
+// The file to read is set by supplying config.Options to the application,
+// e.g. fx.Supply(config.Options{File: cfgFile}).
+var Module = fx.Module("config",
+	fx.Provide(NewSource),
+	fx.Provide(NewViper),
+	fx.Provide(NewValidate),
+	fx.Provide(NewDecoder),
+	fx.Provide(NewReloader),
+	fx.Invoke(Watch),
+)
+
+type SourceParams struct {
+	fx.In
+	Options  config.Options   `optional:"true"`
+	Sections []config.Section `group:"configSections"`
+}
+
+func NewSource(p SourceParams) (*config.Source, error) {
+	opts := p.Options
+	if reflect.ValueOf(opts).IsZero() {
+		// fall back to the options given to the deprecated config.InitConfig
+		opts = config.DefaultOptions()
+	}
+	return config.Load(opts, p.Sections...)
+}
+
+// NewViper provides the settings loaded at startup. Changes of the config
+// files are only visible through config.Source and Reloadable.
+func NewViper(source *config.Source) *viper.Viper {
+	return source.Viper()
+}
+
+type ValidateParams struct {
+	fx.In
+	Validations []config.Validation `group:"configValidations"`
+}
+
+func NewValidate(p ValidateParams) (*validator.Validate, error) {
+	return config.NewValidate(p.Validations...)
+}
+
+// ProvideValidation returns an option making the custom validation tag
+// available to every config section of the application.
+func ProvideValidation(tag string, fn validator.Func) fx.Option {
+	return fx.Provide(fx.Annotate(
+		func() config.Validation {
+			return config.Validation{Tag: tag, Func: fn}
+		},
+		fx.ResultTags(`group:"configValidations"`),
+	))
+}
+
+type DecoderParams struct {
+	fx.In
+	Validate        *validator.Validate
+	SecretResolvers []config.SecretResolver `group:"secretResolvers"`
+}
+
+func NewDecoder(p DecoderParams) (*config.Decoder, error) {
+	return config.NewDecoder(p.Validate, p.SecretResolvers...)
+}
+
+// AsSecretResolver annotates a constructor of a config.SecretResolver so
+// that the references it handles are resolved in every config section.
+func AsSecretResolver(resolver any) any {
+	return fx.Annotate(
+		resolver,
+		fx.As(new(config.SecretResolver)),
+		fx.ResultTags(`group:"secretResolvers"`),
+	)
+}
+
+// Provide adds T as the config section at key to the config.Source of the
+// application, with the given default values, and returns an option providing
+// *T, decoded from the config subtree at key and validated against the
+// `validate` struct tags of T. The application fails to start with a list of
+// every invalid key if validation fails.
+//
+// *Reloadable[T] is provided as well for components that need to follow
+// changes of the config file at runtime.
+func Provide[T any](key string, defaults T) fx.Option {
+	section := config.NewSection(key, defaults)
+	return fx.Options(
+		fx.Provide(fx.Annotate(
+			func() config.Section {
+				return section
+			},
+			fx.ResultTags(`group:"configSections"`),
+		)),
+		fx.Provide(func(source *config.Source, decoder *config.Decoder, reloader *Reloader) (*Reloadable[T], error) {
+			r := &Reloadable[T]{key: key, decoder: decoder}
+			commit, err := r.prepare(source.Viper())
+			if err != nil {
+				return nil, err
+			}
+			commit()
+			reloader.register(r)
+			return r, nil
+		}),
+		fx.Provide(func(r *Reloadable[T]) *T {
+			return r.Load()
+		}),
+	)
+}
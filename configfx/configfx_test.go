@@ -0,0 +1,278 @@
+package configfx_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/fx"
+
+	"github.com/astaclinic/astafx/config"
+	"github.com/astaclinic/astafx/configfx"
+)
+
+type testConfig struct {
+	Host  string `mapstructure:"host" validate:"required"`
+	Port  int    `mapstructure:"port" validate:"min=1,max=65535"`
+	Inner struct {
+		UserName string `mapstructure:"user_name" validate:"required"`
+	} `mapstructure:"inner"`
+}
+
+// otherConfig is a second section type, as fx provides one value per type
+type otherConfig testConfig
+
+type staticResolver struct{}
+
+func (staticResolver) Scheme() string {
+	return "static"
+}
+
+func (staticResolver) Resolve(ref string) (string, error) {
+	return ref, nil
+}
+
+func writeConfig(t *testing.T, file string, content string) string {
+	t.Helper()
+	if file == "" {
+		file = filepath.Join(t.TempDir(), "config.yaml")
+	}
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write config file %s: %v", file, err)
+	}
+	return file
+}
+
+func TestProvide(t *testing.T) {
+	t.Run("Test valid config", func(t *testing.T) {
+		file := writeConfig(t, "", `
+test:
+  host: localhost
+  port: 5432
+  inner:
+    user_name: asta
+`)
+		var cfg *testConfig
+		app := fx.New(
+			fx.NopLogger,
+			fx.Supply(config.Options{File: file}),
+			configfx.Module,
+			configfx.Provide("test", testConfig{}),
+			fx.Populate(&cfg),
+		)
+		if err := app.Err(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Host != "localhost" || cfg.Port != 5432 || cfg.Inner.UserName != "asta" {
+			t.Errorf("unexpected config value, got %+v", cfg)
+		}
+	})
+	t.Run("Test secret references", func(t *testing.T) {
+		secretFile := filepath.Join(t.TempDir(), "host")
+		if err := os.WriteFile(secretFile, []byte("secret-host\n"), 0o600); err != nil {
+			t.Fatalf("failed to write secret file: %v", err)
+		}
+		t.Setenv("CONFIGFX_SECRET_PORT", "6432")
+		file := writeConfig(t, "", `
+test:
+  host: file://`+secretFile+`
+  port: env://CONFIGFX_SECRET_PORT
+  inner:
+    user_name: static://asta
+`)
+		var cfg *testConfig
+		app := fx.New(
+			fx.NopLogger,
+			fx.Supply(config.Options{File: file}),
+			configfx.Module,
+			fx.Provide(configfx.AsSecretResolver(func() staticResolver { return staticResolver{} })),
+			configfx.Provide("test", testConfig{}),
+			fx.Populate(&cfg),
+		)
+		if err := app.Err(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Host != "secret-host" || cfg.Port != 6432 || cfg.Inner.UserName != "asta" {
+			t.Errorf("unexpected config value, got %+v", cfg)
+		}
+	})
+	t.Run("Test invalid config lists every key", func(t *testing.T) {
+		file := writeConfig(t, "", `
+test:
+  port: 0
+`)
+		var cfg *testConfig
+		app := fx.New(
+			fx.NopLogger,
+			fx.Supply(config.Options{File: file}),
+			configfx.Module,
+			configfx.Provide("test", testConfig{}),
+			fx.Populate(&cfg),
+		)
+		err := app.Err()
+		if err == nil {
+			t.Fatalf("expected validation error")
+		}
+		for _, expected := range []string{
+			"test.host: failed on the 'required' validation",
+			"test.port: failed on the 'min=1' validation",
+			"test.inner.user_name: failed on the 'required' validation",
+		} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("error %q does not contain %q", err, expected)
+			}
+		}
+	})
+	t.Run("Test invalid config lists the keys of every section", func(t *testing.T) {
+		file := writeConfig(t, "", `
+test:
+  port: 0
+other:
+  host: localhost
+  port: 5432
+`)
+		var cfg *testConfig
+		app := fx.New(
+			fx.NopLogger,
+			fx.Supply(config.Options{File: file}),
+			configfx.Module,
+			configfx.Provide("test", testConfig{}),
+			configfx.Provide("other", otherConfig{}),
+			fx.Populate(&cfg),
+		)
+		err := app.Err()
+		if err == nil {
+			t.Fatalf("expected validation error")
+		}
+		for _, expected := range []string{
+			"test.host: failed on the 'required' validation",
+			"test.port: failed on the 'min=1' validation",
+			"other.inner.user_name: failed on the 'required' validation",
+		} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("error %q does not contain %q", err, expected)
+			}
+		}
+	})
+}
+
+func TestIsolatedApps(t *testing.T) {
+	for _, host := range []string{"first", "second", "third"} {
+		host := host
+		t.Run("Test app with host "+host, func(t *testing.T) {
+			t.Parallel()
+			file := writeConfig(t, "", `
+test:
+  host: `+host+`
+  port: 5432
+  inner:
+    user_name: asta
+`)
+			var cfg *testConfig
+			app := fx.New(
+				fx.NopLogger,
+				fx.Supply(config.Options{File: file}),
+				configfx.Module,
+				configfx.Provide("test", testConfig{}),
+				fx.Populate(&cfg),
+			)
+			if err := app.Err(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.Host != host {
+				t.Errorf("unexpected config value, got %s, expected %s", cfg.Host, host)
+			}
+		})
+		t.Run("Test app with default host "+host, func(t *testing.T) {
+			t.Parallel()
+			file := writeConfig(t, "", `
+test:
+  inner:
+    user_name: asta
+`)
+			var cfg *testConfig
+			var source *config.Source
+			app := fx.New(
+				fx.NopLogger,
+				fx.Supply(config.Options{File: file}),
+				configfx.Module,
+				configfx.Provide("test", testConfig{Host: host, Port: 5432}),
+				fx.Populate(&cfg, &source),
+			)
+			if err := app.Err(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.Host != host {
+				t.Errorf("unexpected default value, got %s, expected %s", cfg.Host, host)
+			}
+			sections := source.Sections()
+			if len(sections) != 1 || sections[0].Defaults.(testConfig).Host != host {
+				t.Errorf("unexpected sections of the source, got %v", sections)
+			}
+		})
+	}
+}
+
+func TestReloader(t *testing.T) {
+	file := writeConfig(t, "", `
+test:
+  host: localhost
+  port: 5432
+  inner:
+    user_name: asta
+`)
+	var reloader *configfx.Reloader
+	var reloadable *configfx.Reloadable[testConfig]
+	app := fx.New(
+		fx.NopLogger,
+		fx.Supply(config.Options{File: file}),
+		configfx.Module,
+		configfx.Provide("test", testConfig{}),
+		fx.Populate(&reloader, &reloadable),
+	)
+	if err := app.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var gotOld, gotNew *testConfig
+	reloadable.Subscribe(func(old, new *testConfig) {
+		gotOld, gotNew = old, new
+	})
+
+	t.Run("Test invalid config is rejected", func(t *testing.T) {
+		writeConfig(t, file, `
+test:
+  host: localhost
+  port: 0
+  inner:
+    user_name: asta
+`)
+		if err := reloader.Reload(); err == nil {
+			t.Errorf("expected validation error")
+		}
+		if got := reloadable.Load().Port; got != 5432 {
+			t.Errorf("invalid config swapped in, got port %d", got)
+		}
+		if gotNew != nil {
+			t.Errorf("subscriber notified of invalid config")
+		}
+	})
+	t.Run("Test valid config is swapped in", func(t *testing.T) {
+		writeConfig(t, file, `
+test:
+  host: localhost
+  port: 6432
+  inner:
+    user_name: asta
+`)
+		if err := reloader.Reload(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := reloadable.Load().Port; got != 6432 {
+			t.Errorf("unexpected config value, got port %d, expected 6432", got)
+		}
+		if gotOld == nil || gotOld.Port != 5432 || gotNew == nil || gotNew.Port != 6432 {
+			t.Errorf("subscriber not notified with old and new value, got %+v, %+v", gotOld, gotNew)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package configfx
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/astaclinic/astafx/config"
+)
+
+// Reloadable holds the latest valid value of a config section and notifies
+// subscribers when a reload changes it.
+type Reloadable[T any] struct {
+	key         string
+	decoder     *config.Decoder
+	value       atomic.Pointer[T]
+	mu          sync.Mutex
+	subscribers []func(old, new *T)
+}
+
+// Key returns the config key of the section.
+func (r *Reloadable[T]) Key() string {
+	return r.key
+}
+
+// Load returns the current value of the section. The returned value must not
+// be modified.
+func (r *Reloadable[T]) Load() *T {
+	return r.value.Load()
+}
+
+// Subscribe registers fn to be called with the old and new value whenever a
+// reload changes the section.
+func (r *Reloadable[T]) Subscribe(fn func(old, new *T)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribers = append(r.subscribers, fn)
+}
+
+// prepare decodes and validates the section from v, returning a function that
+// swaps in the new value and notifies the subscribers.
+func (r *Reloadable[T]) prepare(v *viper.Viper) (func(), error) {
+	next := new(T)
+	if err := r.decoder.Unmarshal(v, r.key, next); err != nil {
+		return nil, err
+	}
+	return func() {
+		old := r.value.Swap(next)
+		if old == nil || reflect.DeepEqual(old, next) {
+			return
+		}
+		r.mu.Lock()
+		subscribers := append([]func(old, new *T){}, r.subscribers...)
+		r.mu.Unlock()
+		for _, fn := range subscribers {
+			fn(old, next)
+		}
+	}, nil
+}
+
+type section interface {
+	prepare(v *viper.Viper) (func(), error)
+}
+
+// Reloader re-decodes every section provided with Provide when the config
+// changes. The new settings and values are only swapped in if all sections
+// are valid.
+type Reloader struct {
+	mu       sync.Mutex
+	source   *config.Source
+	sections []section
+}
+
+// NewReloader validates every section of the source before any of them is
+// provided, so that the application fails to start once with every invalid
+// key rather than with the first invalid section only.
+func NewReloader(source *config.Source, decoder *config.Decoder) (*Reloader, error) {
+	var errs error
+	for _, section := range source.Sections() {
+		out := reflect.New(section.Type).Interface()
+		errs = multierr.Append(errs, decoder.Unmarshal(source.Viper(), section.Key, out))
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return &Reloader{source: source}, nil
+}
+
+func (r *Reloader) register(s section) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sections = append(r.sections, s)
+}
+
+// Reload reads the config files again and validates every section against
+// them. If all of them are valid, the new settings and values are swapped in.
+// Otherwise the current ones are kept and an error listing every invalid key
+// is returned.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var commits []func()
+	err := r.source.Reload(func(v *viper.Viper) error {
+		var errs error
+		for _, s := range r.sections {
+			commit, err := s.prepare(v)
+			if err != nil {
+				errs = multierr.Append(errs, err)
+				continue
+			}
+			commits = append(commits, commit)
+		}
+		return errs
+	})
+	if err != nil {
+		return err
+	}
+	for _, commit := range commits {
+		commit()
+	}
+	return nil
+}
+
+type WatchParams struct {
+	fx.In
+	Lifecycle fx.Lifecycle
+	Source    *config.Source
+	Reloader  *Reloader
+	Logger    *zap.SugaredLogger `optional:"true"`
+}
+
+// Watch reloads the config whenever one of the config files is changed.
+func Watch(p WatchParams) {
+	var stop func() error
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			var err error
+			stop, err = p.Source.Watch(func() {
+				if err := p.Reloader.Reload(); err != nil {
+					if p.Logger != nil {
+						p.Logger.Errorw("rejected config reload, keeping current config", "err", err)
+					}
+					return
+				}
+				if p.Logger != nil {
+					p.Logger.Infow("reloaded config", "files", p.Source.Files())
+				}
+			}, func(err error) {
+				if p.Logger != nil {
+					p.Logger.Errorw("error in watching config files", "err", err)
+				}
+			})
+			return err
+		},
+		OnStop: func(context.Context) error {
+			return stop()
+		},
+	})
+}
@@ -1,47 +1,230 @@
 package dbfx
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
 
-	"github.com/spf13/viper"
 	"go.uber.org/fx"
+	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+
+	"github.com/astaclinic/astafx/config"
+	"github.com/astaclinic/astafx/configfx"
+	"github.com/astaclinic/astafx/healthfx"
+	"github.com/astaclinic/astafx/retry"
+	"github.com/astaclinic/astafx/routerfx"
 )
 
 type PostgresConfig struct {
-	UserName string `mapstructure:"user_name" yaml:"user_name" validate:"required"`
-	Password string `mapstructure:"password" yaml:"password" validate:"required"`
-	Host     string `mapstructure:"host" yaml:"host" validate:"required"`
-	Database string `mapstructure:"database" yaml:"database" validate:"required"`
-	Port     string `mapstructure:"port" yaml:"port" validate:"required"`
+	// Dsn is a postgres:// URL or libpq key/value settings of the database,
+	// the structured fields are used if it is not set
+	Dsn      string `mapstructure:"dsn" yaml:"dsn" validate:"omitempty,postgres_dsn" secret:"true"`
+	UserName string `mapstructure:"user_name" yaml:"user_name" validate:"required_without=Dsn"`
+	Password string `mapstructure:"password" yaml:"password" secret:"true"`
+	Host     string `mapstructure:"host" yaml:"host" validate:"required_without=Dsn"`
+	Database string `mapstructure:"database" yaml:"database" validate:"required_without=Dsn"`
+	Port     string `mapstructure:"port" yaml:"port" validate:"required_without=Dsn"`
+
+	SSLMode     string `mapstructure:"sslmode" yaml:"sslmode" validate:"omitempty,oneof=disable allow prefer require verify-ca verify-full"`
+	SSLRootCert string `mapstructure:"sslrootcert" yaml:"sslrootcert" validate:"omitempty,file"`
+	SSLCert     string `mapstructure:"sslcert" yaml:"sslcert" validate:"omitempty,file"`
+	SSLKey      string `mapstructure:"sslkey" yaml:"sslkey" validate:"omitempty,file"`
+
+	SearchPath      string        `mapstructure:"search_path" yaml:"search_path"`
+	ApplicationName string        `mapstructure:"application_name" yaml:"application_name"`
+	ConnectTimeout  time.Duration `mapstructure:"connect_timeout" yaml:"connect_timeout" validate:"min=0"`
+	// Params are runtime parameters set on every connection, such as
+	// statement_timeout
+	Params map[string]string `mapstructure:"params" yaml:"params"`
+
+	Pool     PoolConfig    `mapstructure:"pool" yaml:"pool"`
+	Replicas ReplicaConfig `mapstructure:"replicas" yaml:"replicas"`
+	Tx       TxConfig      `mapstructure:"tx" yaml:"tx"`
+	// Retry is the policy of the connection at startup
+	Retry retry.Policy `mapstructure:"retry" yaml:"retry"`
 }
 
-func init() {
-	// config must have a default value for viper to load config from env variables
-	// default value of empty string (zero value) will not pass the "required" config validation
-	viper.SetDefault("postgres.dsn", "")
+// dsnExclusiveOptions are the options of the config which fail the
+// connection string if the dsn sets them too, rather than being ignored.
+var dsnExclusiveOptions = map[string]bool{
+	"password":    true,
+	"sslmode":     true,
+	"sslrootcert": true,
+	"sslcert":     true,
+	"sslkey":      true,
+}
+
+// ConnString returns the connection string of the database, with the options
+// of the config merged in. The dsn is kept in its URL or key/value form, the
+// options it sets take precedence except for the password and TLS options,
+// which must not be set by both.
+func (c *PostgresConfig) ConnString() (string, error) {
+	if c.Dsn != "" && !isURLDsn(c.Dsn) {
+		settings, err := parseKeyValueDsn(c.Dsn)
+		if err != nil {
+			return "", err
+		}
+		err = c.mergeOptions(func(key string) bool {
+			_, ok := settings[key]
+			return ok
+		}, func(key, value string) {
+			settings[key] = value
+		})
+		if err != nil {
+			return "", err
+		}
+		return formatKeyValueDsn(settings), nil
+	}
+
+	var u *url.URL
+	if c.Dsn != "" {
+		var err error
+		if u, err = url.Parse(c.Dsn); err != nil {
+			return "", fmt.Errorf("invalid postgres dsn: %w", err)
+		}
+	} else {
+		u = &url.URL{
+			Scheme: "postgres",
+			User:   url.User(c.UserName),
+			Host:   net.JoinHostPort(c.Host, c.Port),
+			Path:   "/" + c.Database,
+		}
+	}
+	query := u.Query()
+	err := c.mergeOptions(func(key string) bool {
+		if key == "password" {
+			_, ok := u.User.Password()
+			return ok
+		}
+		return query.Has(key)
+	}, func(key, value string) {
+		if key == "password" {
+			u.User = url.UserPassword(u.User.Username(), value)
+			return
+		}
+		query.Set(key, value)
+	})
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// mergeOptions sets the options of the config which are not set yet.
+func (c *PostgresConfig) mergeOptions(has func(key string) bool, set func(key, value string)) error {
+	options := make(map[string]string, len(c.Params)+7)
+	for key, value := range c.Params {
+		options[key] = value
+	}
+	for key, value := range map[string]string{
+		"password":         c.Password,
+		"sslmode":          c.SSLMode,
+		"sslrootcert":      c.SSLRootCert,
+		"sslcert":          c.SSLCert,
+		"sslkey":           c.SSLKey,
+		"search_path":      c.SearchPath,
+		"application_name": c.ApplicationName,
+	} {
+		if value != "" {
+			options[key] = value
+		}
+	}
+	if c.ConnectTimeout > 0 {
+		// the timeout is in seconds, rounded up to keep a sub-second timeout
+		options["connect_timeout"] = strconv.Itoa(int((c.ConnectTimeout + time.Second - 1) / time.Second))
+	}
+	for key, value := range options {
+		if !has(key) {
+			set(key, value)
+		} else if dsnExclusiveOptions[key] && c.Dsn != "" {
+			return fmt.Errorf("postgres %s is set by both the dsn and the config", key)
+		}
+	}
+	return nil
 }
 
 type Params struct {
 	fx.In
+	Lifecycle  fx.Lifecycle
 	Config     *PostgresConfig
 	GormLogger *GormLogger
+	Logger     *zap.SugaredLogger
 }
 
 func New(p Params) (*gorm.DB, error) {
-	dsn := fmt.Sprintf("postgres://%s:%s@%s:%s/%s", p.Config.UserName, p.Config.Password, p.Config.Host, p.Config.Port, p.Config.Database)
+	dsn, err := p.Config.ConnString()
+	if err != nil {
+		return nil, err
+	}
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
 		Logger: p.GormLogger,
+		// the database is pinged with retries on start
+		DisableAutomaticPing: true,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("fail to initialize database: %w", err)
 	}
+	if err := db.Use(p.GormLogger); err != nil {
+		return nil, fmt.Errorf("fail to initialize database logger: %w", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("fail to initialize database: %w", err)
+	}
+	p.Config.Pool.apply(sqlDB)
+	// the database is connected on start, so the retries are bounded by the
+	// start timeout of the app, before the hooks of the migrations and the
+	// replicas
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			if err := p.Config.Retry.Do(ctx, p.Logger, "postgres", sqlDB.PingContext); err != nil {
+				sqlDB.Close()
+				return err
+			}
+			return nil
+		},
+	})
+	if err := setupReplicas(p.Lifecycle, db, p.Config, p.Logger); err != nil {
+		return nil, err
+	}
 	return db, nil
 }
 
+// NewHealthChecker checks the connection to the primary database.
+func NewHealthChecker(db *gorm.DB) (*healthfx.FuncChecker, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	return healthfx.NewChecker("postgres", sqlDB.PingContext), nil
+}
+
 var Module = fx.Options(
+	configfx.Provide("postgres", PostgresConfig{
+		Port:            "5432",
+		ApplicationName: config.GetPackageName(),
+		Pool:            defaultPoolConfig(),
+		Replicas:        defaultReplicaConfig(),
+		Tx:              defaultTxConfig(),
+		Retry:           retry.DefaultPolicy(),
+	}),
+	configfx.ProvideValidation("postgres_dsn", validateDsn),
+	configfx.Provide("gorm", defaultGormLoggerConfig()),
 	fx.Provide(New),
 	fx.Provide(NewGormLogger),
+	fx.Provide(NewTxManager),
+	fx.Provide(NewSlowQueries),
+	configfx.Provide("migrations", defaultMigrationConfig()),
+	fx.Provide(NewMigrator),
+	fx.Invoke(RunMigrations),
+	fx.Provide(routerfx.AsHandlerRoute(NewSlowQueryHandler)),
 	fx.Invoke(SetupGormPrometheus),
+	fx.Invoke(RegisterPoolMetrics),
+	fx.Provide(healthfx.AsChecker(NewHealthChecker)),
 )
@@ -0,0 +1,101 @@
+package dbfx
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgconn"
+)
+
+// isURLDsn reports whether the dsn is a postgres:// URL rather than libpq
+// key/value settings such as "host=db dbname=clinic".
+func isURLDsn(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// parseKeyValueDsn returns the settings of a libpq key/value dsn, whose
+// values are either unquoted or single-quoted with backslash escapes.
+func parseKeyValueDsn(dsn string) (map[string]string, error) {
+	settings := map[string]string{}
+	s := strings.TrimSpace(dsn)
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("invalid postgres dsn: missing = after %q", s)
+		}
+		key := strings.TrimSpace(s[:eq])
+		if key == "" || strings.ContainsAny(key, " \t\n'") {
+			return nil, fmt.Errorf("invalid postgres dsn: invalid key %q", key)
+		}
+		s = strings.TrimLeft(s[eq+1:], " \t\n")
+
+		var value strings.Builder
+		quoted := strings.HasPrefix(s, "'")
+		if quoted {
+			s = s[1:]
+		}
+		closed := false
+		i := 0
+		for ; i < len(s); i++ {
+			c := s[i]
+			switch {
+			case c == '\\' && i+1 < len(s):
+				i++
+				value.WriteByte(s[i])
+			case quoted && c == '\'':
+				closed = true
+			case !quoted && (c == ' ' || c == '\t' || c == '\n'):
+				closed = true
+			default:
+				value.WriteByte(c)
+				continue
+			}
+			if closed {
+				break
+			}
+		}
+		if quoted && !closed {
+			return nil, errors.New("invalid postgres dsn: unterminated quoted value")
+		}
+		settings[key] = value.String()
+		if i < len(s) {
+			i++
+		}
+		s = strings.TrimSpace(s[i:])
+	}
+	return settings, nil
+}
+
+// formatKeyValueDsn returns the libpq key/value dsn of the settings, sorted
+// by key.
+func formatKeyValueDsn(settings map[string]string) string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	for i, key := range keys {
+		pairs[i] = key + "='" + replacer.Replace(settings[key]) + "'"
+	}
+	return strings.Join(pairs, " ")
+}
+
+// validateDsn checks the connection string of the PostgresConfig holding
+// the dsn field, in URL or key/value form.
+func validateDsn(fieldLevel validator.FieldLevel) bool {
+	config, ok := fieldLevel.Parent().Interface().(PostgresConfig)
+	if !ok {
+		return false
+	}
+	dsn, err := config.ConnString()
+	if err != nil {
+		return false
+	}
+	_, err = pgconn.ParseConfig(dsn)
+	return err == nil
+}
@@ -7,72 +7,278 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/fx"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+
+	"github.com/astaclinic/astafx/configfx"
+	astalogger "github.com/astaclinic/astafx/logger"
 )
 
-var ErrRecordNotFound = errors.New("record not found")
+var ErrRecordNotFound = logger.ErrRecordNotFound
+
+type GormLogLevel string
+
+var (
+	SilentLogLevel GormLogLevel = "silent"
+	ErrorLogLevel  GormLogLevel = "error"
+	WarnLogLevel   GormLogLevel = "warn"
+	InfoLogLevel   GormLogLevel = "info"
+)
+
+var gormLogLevelMap = map[GormLogLevel]logger.LogLevel{
+	SilentLogLevel: logger.Silent,
+	ErrorLogLevel:  logger.Error,
+	WarnLogLevel:   logger.Warn,
+	InfoLogLevel:   logger.Info,
+}
 
 type GormLoggerConfig struct {
-	SlowThreshold             time.Duration
-	IgnoreRecordNotFoundError bool
+	LogLevel GormLogLevel `mapstructure:"log_level" yaml:"log_level" validate:"required,oneof=silent error warn info"`
+	// SlowThreshold is the duration of the statements logged as slow, 0 disables it
+	SlowThreshold             time.Duration `mapstructure:"slow_threshold" yaml:"slow_threshold"`
+	IgnoreRecordNotFoundError bool          `mapstructure:"ignore_record_not_found_error" yaml:"ignore_record_not_found_error"`
+	// ParameterizedQueries omits the bound params of the statements from the
+	// logs. Otherwise they are logged as the params field, which is masked by
+	// the default redaction of the logs
+	ParameterizedQueries bool `mapstructure:"parameterized_queries" yaml:"parameterized_queries"`
+	// ExplainThreshold is the duration of the statements explained by
+	// EXPLAIN, 0 disables it. EXPLAIN runs in the background, so the plan is
+	// logged by a follow-up "Explained slow SQL statement" line and added to
+	// a Sentry breadcrumb, rather than to the line of the statement
+	ExplainThreshold time.Duration `mapstructure:"explain_threshold" yaml:"explain_threshold" validate:"omitempty,gtefield=SlowThreshold"`
+	// SlowQueries is the number of the slowest statements kept for the
+	// slow query route, 0 disables it
+	SlowQueries int `mapstructure:"slow_queries" yaml:"slow_queries" validate:"min=0"`
+	// SlowQueriesToken is the bearer token required by the slow query
+	// route, which is disabled if it is empty
+	SlowQueriesToken string `mapstructure:"slow_queries_token" yaml:"slow_queries_token" secret:"true"`
+}
+
+func defaultGormLoggerConfig() GormLoggerConfig {
+	return GormLoggerConfig{
+		LogLevel:                  InfoLogLevel,
+		SlowThreshold:             time.Second, // Slow SQL threshold
+		IgnoreRecordNotFoundError: true,        // Ignore ErrRecordNotFound error for logger
+		ParameterizedQueries:      true,
+		SlowQueries:               20,
+	}
 }
 
 type GormLogger struct {
 	logger *zap.SugaredLogger
-	config GormLoggerConfig
+	config *configfx.Reloadable[GormLoggerConfig]
+	// level is set by LogMode, overriding the configured level
+	level *logger.LogLevel
+	slow  *SlowQueries
+	// hub records the slow statements of contexts without a hub of their own
+	hub *sentry.Hub
+	// explainer is shared with the copies by LogMode, its database is set by
+	// Initialize
+	explainer *explainer
 }
 
-func NewGormLogger(logger *zap.SugaredLogger) *GormLogger {
-	return &GormLogger{logger, GormLoggerConfig{
-		SlowThreshold:             time.Second, // Slow SQL threshold
-		IgnoreRecordNotFoundError: true,        // Ignore ErrRecordNotFound error for logger
-	}}
+type GormLoggerParams struct {
+	fx.In
+	Lifecycle   fx.Lifecycle
+	Logger      *zap.SugaredLogger
+	Config      *configfx.Reloadable[GormLoggerConfig]
+	SlowQueries *SlowQueries
+	Hub         *sentry.Hub `optional:"true"`
 }
 
-func (g *GormLogger) LogMode(logger.LogLevel) logger.Interface {
-	return g
+// NewGormLogger returns the logger of gorm, which follows the reloads of the
+// config.
+func NewGormLogger(p GormLoggerParams) *GormLogger {
+	return &GormLogger{
+		logger:    p.Logger,
+		config:    p.Config,
+		slow:      p.SlowQueries,
+		hub:       p.Hub,
+		explainer: newExplainer(p.Lifecycle, p.SlowQueries),
+	}
+}
+
+func (g *GormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	newLogger := *g
+	newLogger.level = &level
+	return &newLogger
+}
+
+// logLevel returns the level set by LogMode, or the configured level.
+func (g *GormLogger) logLevel(config *GormLoggerConfig) logger.LogLevel {
+	if g.level != nil {
+		return *g.level
+	}
+	return gormLogLevelMap[config.LogLevel]
+}
+
+// loggerFor returns the logger of the request of ctx, to correlate the
+// queries with the request. The caller is logged as a field set to the caller
+// of gorm.
+func (g *GormLogger) loggerFor(ctx context.Context) *zap.SugaredLogger {
+	return astalogger.FromContext(ctx, g.logger).Desugar().WithOptions(zap.WithCaller(false)).Sugar()
+}
+
+// trimCaller returns the file:line of utils.FileWithLineNum in the trimmed
+// form of the zap callers.
+func trimCaller(caller string) string {
+	i := strings.LastIndexByte(caller, ':')
+	if i < 0 {
+		return caller
+	}
+	line, _ := strconv.Atoi(caller[i+1:])
+	return zapcore.EntryCaller{Defined: true, File: caller[:i], Line: line}.TrimmedPath()
 }
 
 func (g *GormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
-	g.logger.Info(msg, data)
+	if g.logLevel(g.config.Load()) >= logger.Info {
+		g.loggerFor(ctx).With("caller", trimCaller(utils.FileWithLineNum())).Infof(msg, data...)
+	}
 }
 
 func (g *GormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
-	g.logger.Warn(msg, data)
+	if g.logLevel(g.config.Load()) >= logger.Warn {
+		g.loggerFor(ctx).With("caller", trimCaller(utils.FileWithLineNum())).Warnf(msg, data...)
+	}
 }
 
 func (g *GormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
-	g.logger.Error(msg, data)
+	if g.logLevel(g.config.Load()) >= logger.Error {
+		g.loggerFor(ctx).With("caller", trimCaller(utils.FileWithLineNum())).Errorf(msg, data...)
+	}
 }
 
 func (g *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	config := g.config.Load()
+	level := g.logLevel(config)
 	elapsed := time.Since(begin)
 	msg := "Executed SQL statement"
+
+	var log func(msg string, keysAndValues ...interface{})
+	var fields []interface{}
+	slow := elapsed > config.SlowThreshold && config.SlowThreshold != 0
 	switch {
-	case err != nil && (!errors.Is(err, ErrRecordNotFound) || !g.config.IgnoreRecordNotFoundError):
-		sql, rows := fc()
-		if rows == -1 {
-			g.logger.Errorw(msg, "err", err, "time", float64(elapsed.Nanoseconds())/1e6, "rows", "-", "sql", sql)
-		} else {
-			g.logger.Errorw(msg, "err", err, "time", float64(elapsed.Nanoseconds())/1e6, "rows", rows, "sql", sql)
-		}
-	case elapsed > g.config.SlowThreshold && g.config.SlowThreshold != 0:
-		sql, rows := fc()
-		slowLog := fmt.Sprintf("SLOW SQL >= %v", g.config.SlowThreshold)
-		if rows == -1 {
-			g.logger.Warnw(msg, "err", slowLog, "time", float64(elapsed.Nanoseconds())/1e6, "rows", "-", "sql", sql)
-		} else {
-			g.logger.Warnw(msg, "err", slowLog, "time", float64(elapsed.Nanoseconds())/1e6, "rows", rows, "sql", sql)
+	case err != nil && level >= logger.Error && (!errors.Is(err, ErrRecordNotFound) || !config.IgnoreRecordNotFoundError):
+		log = g.loggerFor(ctx).Errorw
+		fields = append(fields, "err", err)
+	case slow && level >= logger.Warn:
+		log = g.loggerFor(ctx).Warnw
+		fields = append(fields, "err", fmt.Sprintf("SLOW SQL >= %v", config.SlowThreshold))
+	case level >= logger.Info:
+		log = g.loggerFor(ctx).Infow
+	}
+	// the slow statements are recorded regardless of the log level
+	if log == nil && !slow {
+		return
+	}
+
+	sql, rows := fc()
+	statement, captured := ctx.Value(statementKey{}).(*capturedStatement)
+	if captured {
+		// log the statement with placeholders rather than the explained SQL
+		sql = statement.sql
+	}
+	if slow {
+		g.recordSlow(ctx, config, sql, statement, elapsed)
+	}
+	if log == nil {
+		return
+	}
+	if captured && !config.ParameterizedQueries {
+		fields = append(fields, "params", statement.vars)
+	}
+	fields = append(fields, "time", float64(elapsed.Nanoseconds())/1e6)
+	if rows == -1 {
+		fields = append(fields, "rows", "-")
+	} else {
+		fields = append(fields, "rows", rows)
+	}
+	fields = append(fields, "sql", sql, "caller", trimCaller(utils.FileWithLineNum()))
+	log(msg, fields...)
+}
+
+// recordSlow records the slow statement for the slow query route and Sentry,
+// and queues its EXPLAIN. The statement is nil if it was not captured.
+func (g *GormLogger) recordSlow(ctx context.Context, config *GormLoggerConfig, sql string, statement *capturedStatement, elapsed time.Duration) {
+	g.slow.record(sql, elapsed)
+	hub := g.hubFor(ctx)
+	addSlowQueryBreadcrumb(hub, sql, elapsed, nil)
+	// statements of a transaction may use its uncommitted state, which
+	// EXPLAIN on another connection cannot see
+	if statement != nil && !statement.inTx && config.ExplainThreshold != 0 && elapsed > config.ExplainThreshold {
+		g.explainer.submit(explainJob{
+			logger:    g.loggerFor(ctx),
+			hub:       hub,
+			sql:       sql,
+			statement: statement,
+			duration:  elapsed,
+		})
+	}
+}
+
+// hubFor returns the Sentry hub of the request of ctx, or the hub of the app.
+func (g *GormLogger) hubFor(ctx context.Context) *sentry.Hub {
+	if hub := sentry.GetHubFromContext(ctx); hub != nil {
+		return hub
+	}
+	return g.hub
+}
+
+type statementKey struct{}
+
+type capturedStatement struct {
+	sql  string
+	vars []interface{}
+	inTx bool
+}
+
+func (g *GormLogger) Name() string {
+	return "astafx:logger"
+}
+
+// Initialize registers the callbacks capturing the SQL with placeholders and
+// the bound params of every statement for Trace, as gorm only passes the
+// explained SQL. The database is kept to EXPLAIN the slow statements.
+func (g *GormLogger) Initialize(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	g.explainer.db.Store(sqlDB)
+	capture := func(db *gorm.DB) {
+		if db.Statement.SQL.Len() == 0 {
+			return
 		}
-	default:
-		sql, rows := fc()
-		if rows == -1 {
-			g.logger.Infow(msg, "time", float64(elapsed.Nanoseconds())/1e6, "rows", "-", "sql", sql)
-		} else {
-			g.logger.Infow(msg, "time", float64(elapsed.Nanoseconds())/1e6, "rows", rows, "sql", sql)
+		vars := make([]interface{}, len(db.Statement.Vars))
+		copy(vars, db.Statement.Vars)
+		_, inTx := db.Statement.ConnPool.(gorm.TxCommitter)
+		db.Statement.Context = context.WithValue(db.Statement.Context, statementKey{}, &capturedStatement{
+			sql:  db.Statement.SQL.String(),
+			vars: vars,
+			inTx: inTx,
+		})
+	}
+	callback := db.Callback()
+	for _, err := range []error{
+		callback.Create().After("*").Register("astafx:capture_statement", capture),
+		callback.Query().After("*").Register("astafx:capture_statement", capture),
+		callback.Update().After("*").Register("astafx:capture_statement", capture),
+		callback.Delete().After("*").Register("astafx:capture_statement", capture),
+		callback.Row().After("*").Register("astafx:capture_statement", capture),
+		callback.Raw().After("*").Register("astafx:capture_statement", capture),
+	} {
+		if err != nil {
+			return err
 		}
 	}
+	return nil
 }
@@ -0,0 +1,166 @@
+package dbfx
+
+import (
+	"testing"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/astaclinic/astafx/config"
+	"github.com/astaclinic/astafx/configfx"
+)
+
+type patient struct {
+	ID   uint
+	Name string
+}
+
+type dryRunDB struct {
+	*gorm.DB
+	logs     *observer.ObservedLogs
+	logger   *GormLogger
+	slow     *SlowQueries
+	config   *configfx.Reloadable[GormLoggerConfig]
+	reloader *configfx.Reloader
+}
+
+// newDryRunDB returns a database building the statements without executing
+// them, logged by the GormLogger into the observed logs. The app is not
+// started, so the slow statements to explain stay queued.
+func newDryRunDB(t *testing.T) *dryRunDB {
+	t.Helper()
+	core, logs := observer.New(zap.DebugLevel)
+	var gormLogger *GormLogger
+	var slow *SlowQueries
+	var reloader *configfx.Reloader
+	var gormConfig *configfx.Reloadable[GormLoggerConfig]
+	app := fx.New(
+		fx.NopLogger,
+		fx.Supply(config.Options{EnvPrefix: "asta"}),
+		fx.Supply(zap.New(core).Sugar()),
+		configfx.Module,
+		configfx.Provide("gorm", defaultGormLoggerConfig()),
+		fx.Provide(NewSlowQueries),
+		fx.Provide(NewGormLogger),
+		fx.Populate(&gormLogger, &slow, &reloader, &gormConfig),
+	)
+	if err := app.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db, err := gorm.Open(postgres.Open("postgres://localhost/test"), &gorm.Config{
+		Logger:               gormLogger,
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.Use(gormLogger); err != nil {
+		t.Fatalf("failed to initialize logger: %v", err)
+	}
+	return &dryRunDB{DB: db, logs: logs, logger: gormLogger, slow: slow, config: gormConfig, reloader: reloader}
+}
+
+func TestGormLogger(t *testing.T) {
+	const query = `SELECT * FROM "patients" WHERE name = $1`
+
+	t.Run("Test captured statement", func(t *testing.T) {
+		db := newDryRunDB(t)
+		db.Where("name = ?", "Jane Doe").Find(&[]patient{})
+		entries := db.logs.FilterMessage("Executed SQL statement").TakeAll()
+		if len(entries) != 1 {
+			t.Fatalf("unexpected entries, got %v", entries)
+		}
+		fields := entries[0].ContextMap()
+		if fields["sql"] != query {
+			t.Errorf("unexpected sql, got %v, expected %s", fields["sql"], query)
+		}
+		if _, ok := fields["params"]; ok {
+			t.Errorf("unexpected params of parameterized query, got %v", fields["params"])
+		}
+	})
+	t.Run("Test params", func(t *testing.T) {
+		t.Setenv("ASTA_GORM_PARAMETERIZED_QUERIES", "false")
+		db := newDryRunDB(t)
+		db.Where("name = ?", "Jane Doe").Find(&[]patient{})
+		entries := db.logs.FilterMessage("Executed SQL statement").TakeAll()
+		if len(entries) != 1 {
+			t.Fatalf("unexpected entries, got %v", entries)
+		}
+		params, ok := entries[0].ContextMap()["params"].([]interface{})
+		if !ok || len(params) != 1 || params[0] != "Jane Doe" {
+			t.Errorf("unexpected params, got %v", entries[0].ContextMap()["params"])
+		}
+	})
+	t.Run("Test slow query", func(t *testing.T) {
+		t.Setenv("ASTA_GORM_SLOW_THRESHOLD", "1ns")
+		db := newDryRunDB(t)
+		db.Where("name = ?", "Jane Doe").Find(&[]patient{})
+		entries := db.logs.FilterMessage("Executed SQL statement").TakeAll()
+		if len(entries) != 1 || entries[0].Level != zap.WarnLevel {
+			t.Fatalf("unexpected entries, got %v", entries)
+		}
+		if got := db.slow.Get(); len(got) != 1 || got[0].SQL != query {
+			t.Errorf("unexpected slow queries, got %+v", got)
+		}
+	})
+	t.Run("Test slow query below the log level", func(t *testing.T) {
+		t.Setenv("ASTA_GORM_LOG_LEVEL", "silent")
+		t.Setenv("ASTA_GORM_SLOW_THRESHOLD", "1ns")
+		t.Setenv("ASTA_GORM_EXPLAIN_THRESHOLD", "1ns")
+		db := newDryRunDB(t)
+		db.Where("name = ?", "Jane Doe").Find(&[]patient{})
+		if entries := db.logs.TakeAll(); len(entries) != 0 {
+			t.Errorf("unexpected entries of silent logger, got %v", entries)
+		}
+		if got := db.slow.Get(); len(got) != 1 || got[0].SQL != query {
+			t.Errorf("unexpected slow queries, got %+v", got)
+		}
+		if got := len(db.logger.explainer.jobs); got != 1 {
+			t.Errorf("unexpected queued statements, got %d, expected 1", got)
+		}
+	})
+	t.Run("Test explain queued", func(t *testing.T) {
+		t.Setenv("ASTA_GORM_SLOW_THRESHOLD", "1ns")
+		t.Setenv("ASTA_GORM_EXPLAIN_THRESHOLD", "1ns")
+		db := newDryRunDB(t)
+		for i := 0; i < explainQueueSize+1; i++ {
+			db.Where("name = ?", "Jane Doe").Find(&[]patient{})
+		}
+		if got := len(db.logger.explainer.jobs); got != explainQueueSize {
+			t.Errorf("unexpected queued statements, got %d, expected %d", got, explainQueueSize)
+		}
+		if entries := db.logs.FilterMessageSnippet("Dropped EXPLAIN").TakeAll(); len(entries) != 1 {
+			t.Errorf("unexpected dropped statements, got %v", entries)
+		}
+		if got := db.slow.Get(); len(got) != 1 || got[0].Plan != nil {
+			t.Errorf("unexpected slow queries, got %+v", got)
+		}
+	})
+	t.Run("Test reload", func(t *testing.T) {
+		db := newDryRunDB(t)
+		t.Setenv("ASTA_GORM_LOG_LEVEL", "warn")
+		t.Setenv("ASTA_GORM_SLOW_THRESHOLD", "1ns")
+		if err := db.reloader.Reload(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		db.Where("name = ?", "Jane Doe").Find(&[]patient{})
+		entries := db.logs.FilterMessage("Executed SQL statement").TakeAll()
+		if len(entries) != 1 || entries[0].Level != zap.WarnLevel {
+			t.Errorf("reloaded config not applied, got %v", entries)
+		}
+
+		t.Setenv("ASTA_GORM_LOG_LEVEL", "silent")
+		if err := db.reloader.Reload(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		db.logs.TakeAll()
+		db.Where("name = ?", "Jane Doe").Find(&[]patient{})
+		if entries := db.logs.TakeAll(); len(entries) != 0 {
+			t.Errorf("unexpected entries after silencing, got %v", entries)
+		}
+	})
+}
@@ -0,0 +1,331 @@
+package dbfx
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type MigrationConfig struct {
+	// Table records the applied versions
+	Table string `mapstructure:"table" yaml:"table" validate:"required"`
+	// RunOnStart applies the pending migrations when the app starts
+	RunOnStart bool `mapstructure:"run_on_start" yaml:"run_on_start"`
+}
+
+func defaultMigrationConfig() MigrationConfig {
+	return MigrationConfig{
+		Table: "schema_migrations",
+	}
+}
+
+// MigrationSource is a directory of SQL migrations in an embed.FS, named as
+// <version>_<name>.up.sql and <version>_<name>.down.sql. The versions are
+// shared by every source.
+type MigrationSource struct {
+	Name string
+	FS   fs.FS
+	Dir  string
+}
+
+// AsMigrationSource annotates a constructor of *MigrationSource to
+// contribute its migrations to the Migrator.
+func AsMigrationSource(source any) any {
+	return fx.Annotate(
+		source,
+		fx.ResultTags(`group:"migrationSources"`),
+	)
+}
+
+type Migration struct {
+	Version int64
+	Name    string
+	Source  string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Source    string
+	AppliedAt *time.Time
+	// Missing is set for applied versions of no source
+	Missing bool
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+func loadMigrations(sources []*MigrationSource) ([]Migration, error) {
+	migrations := map[int64]*Migration{}
+	for _, source := range sources {
+		dir := source.Dir
+		if dir == "" {
+			dir = "."
+		}
+		entries, err := fs.ReadDir(source.FS, dir)
+		if err != nil {
+			return nil, fmt.Errorf("error in reading migrations of %s: %w", source.Name, err)
+		}
+		for _, entry := range entries {
+			match := migrationFile.FindStringSubmatch(entry.Name())
+			if entry.IsDir() || match == nil {
+				continue
+			}
+			version, err := strconv.ParseInt(match[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid migration version %s: %w", entry.Name(), err)
+			}
+			content, err := fs.ReadFile(source.FS, path.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("error in reading migration %s: %w", entry.Name(), err)
+			}
+			migration, ok := migrations[version]
+			if !ok {
+				migration = &Migration{Version: version, Name: match[2], Source: source.Name}
+				migrations[version] = migration
+			} else if migration.Source != source.Name || migration.Name != match[2] {
+				return nil, fmt.Errorf("duplicate migration version %d in %s and %s", version, migration.Source, source.Name)
+			}
+			if match[3] == "up" {
+				migration.Up = string(content)
+			} else {
+				migration.Down = string(content)
+			}
+		}
+	}
+	sorted := make([]Migration, 0, len(migrations))
+	for _, migration := range migrations {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("missing up migration of version %d in %s", migration.Version, migration.Source)
+		}
+		sorted = append(sorted, *migration)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted, nil
+}
+
+// Migrator applies the migrations of the contributed sources. A Postgres
+// advisory lock is held during the operations, so replicas of the app
+// starting together do not race.
+type Migrator struct {
+	db         *sql.DB
+	config     MigrationConfig
+	migrations []Migration
+	logger     *zap.SugaredLogger
+}
+
+type MigratorParams struct {
+	fx.In
+	DB      *gorm.DB
+	Config  *MigrationConfig
+	Sources []*MigrationSource `group:"migrationSources"`
+	Logger  *zap.SugaredLogger
+}
+
+func NewMigrator(p MigratorParams) (*Migrator, error) {
+	sqlDB, err := p.DB.DB()
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := loadMigrations(p.Sources)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         sqlDB,
+		config:     *p.Config,
+		migrations: migrations,
+		logger:     p.Logger,
+	}, nil
+}
+
+// lockID returns the key of the advisory lock, derived from the table.
+func (m *Migrator) lockID() int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte("astafx:migrate:" + m.config.Table))
+	return int64(hash.Sum64())
+}
+
+// withLock runs fn on a connection holding the advisory lock, after creating
+// the table of the applied versions.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", m.lockID()); err != nil {
+		return fmt.Errorf("error in locking migrations: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", m.lockID())
+
+	table := pgx.Identifier{m.config.Table}.Sanitize()
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+table+` (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("error in creating table %s: %w", m.config.Table, err)
+	}
+	return fn(conn)
+}
+
+type appliedMigration struct {
+	name      string
+	appliedAt time.Time
+}
+
+// queryer is a *sql.Conn holding the lock or the *sql.DB.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn queryer) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, applied_at FROM "+pgx.Identifier{m.config.Table}.Sanitize())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int64]appliedMigration{}
+	for rows.Next() {
+		var version int64
+		var migration appliedMigration
+		if err := rows.Scan(&version, &migration.name, &migration.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = migration
+	}
+	return applied, rows.Err()
+}
+
+// run executes the statements of a migration and records it in the same
+// transaction.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	table := pgx.Identifier{m.config.Table}.Sanitize()
+	statements, record := migration.Up, "INSERT INTO "+table+" (version, name) VALUES ($1, $2)"
+	args := []any{migration.Version, migration.Name}
+	if !up {
+		statements, record = migration.Down, "DELETE FROM "+table+" WHERE version = $1"
+		args = args[:1]
+	}
+	if _, err := tx.ExecContext(ctx, statements); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Up applies the pending migrations in the order of their versions.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := m.run(ctx, conn, migration, true); err != nil {
+				return fmt.Errorf("error in applying migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			m.logger.Infow("applied migration", "version", migration.Version, "name", migration.Name, "source", migration.Source)
+		}
+		return nil
+	})
+}
+
+// Down reverts the latest steps applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("missing down migration of version %d in %s", migration.Version, migration.Source)
+			}
+			if err := m.run(ctx, conn, migration, false); err != nil {
+				return fmt.Errorf("error in reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			m.logger.Infow("reverted migration", "version", migration.Version, "name", migration.Name, "source", migration.Source)
+			steps--
+		}
+		return nil
+	})
+}
+
+// Status returns every migration with the time it was applied, in the order
+// of their versions. It reads without the lock, so it does not wait for a
+// running migration, and does not create the table: no migration is applied
+// if the table is missing.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var exists bool
+	table := pgx.Identifier{m.config.Table}.Sanitize()
+	if err := m.db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("error in reading table %s: %w", m.config.Table, err)
+	}
+	applied := map[int64]appliedMigration{}
+	if exists {
+		var err error
+		if applied, err = m.applied(ctx, m.db); err != nil {
+			return nil, err
+		}
+	}
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name, Source: migration.Source}
+		if a, ok := applied[migration.Version]; ok {
+			appliedAt := a.appliedAt
+			status.AppliedAt = &appliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for version, a := range applied {
+		appliedAt := a.appliedAt
+		statuses = append(statuses, MigrationStatus{Version: version, Name: a.name, AppliedAt: &appliedAt, Missing: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// RunMigrations applies the pending migrations when the app starts, if
+// enabled by the config.
+func RunMigrations(lifecycle fx.Lifecycle, migrator *Migrator, config *MigrationConfig) {
+	if !config.RunOnStart {
+		return
+	}
+	lifecycle.Append(fx.Hook{
+		OnStart: migrator.Up,
+	})
+}
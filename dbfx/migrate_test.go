@@ -0,0 +1,45 @@
+package dbfx
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	users := &MigrationSource{Name: "users", Dir: "migrations", FS: fstest.MapFS{
+		"migrations/2_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD email text")},
+		"migrations/2_add_email.down.sql":    {Data: []byte("ALTER TABLE users DROP email")},
+		"migrations/1_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id bigint)")},
+		"migrations/1_create_users.down.sql": {Data: []byte("DROP TABLE users")},
+		"migrations/README.md":               {Data: []byte("not a migration")},
+	}}
+	visits := &MigrationSource{Name: "visits", FS: fstest.MapFS{
+		"3_create_visits.up.sql": {Data: []byte("CREATE TABLE visits (id bigint)")},
+	}}
+
+	t.Run("Test ordering", func(t *testing.T) {
+		migrations, err := loadMigrations([]*MigrationSource{visits, users})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(migrations) != 3 {
+			t.Fatalf("unexpected migrations, got %+v", migrations)
+		}
+		for i, expected := range []string{"create_users", "add_email", "create_visits"} {
+			if migrations[i].Name != expected || migrations[i].Version != int64(i+1) {
+				t.Errorf("unexpected migration %d, got %+v", i, migrations[i])
+			}
+		}
+		if migrations[0].Down != "DROP TABLE users" || migrations[2].Down != "" {
+			t.Errorf("unexpected down migrations, got %+v", migrations)
+		}
+	})
+	t.Run("Test duplicate version", func(t *testing.T) {
+		duplicate := &MigrationSource{Name: "other", FS: fstest.MapFS{
+			"1_create_other.up.sql": {Data: []byte("CREATE TABLE other (id bigint)")},
+		}}
+		if _, err := loadMigrations([]*MigrationSource{users, duplicate}); err == nil {
+			t.Errorf("expected error for duplicate version")
+		}
+	})
+}
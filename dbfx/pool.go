@@ -0,0 +1,87 @@
+package dbfx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.uber.org/fx"
+	"gorm.io/gorm"
+)
+
+// PoolConfig is the connection pool of the database, zero values keep the
+// defaults of database/sql.
+type PoolConfig struct {
+	MaxOpenConns    int           `mapstructure:"max_open_conns" yaml:"max_open_conns" validate:"min=0"`
+	MaxIdleConns    int           `mapstructure:"max_idle_conns" yaml:"max_idle_conns" validate:"min=0"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime" yaml:"conn_max_lifetime" validate:"min=0"`
+	ConnMaxIdleTime time.Duration `mapstructure:"conn_max_idle_time" yaml:"conn_max_idle_time" validate:"min=0"`
+}
+
+func defaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MaxIdleConns: 2,
+	}
+}
+
+func (c PoolConfig) apply(db *sql.DB) {
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+}
+
+// RegisterPoolMetrics exports the sql.DBStats of the connection pool, such as
+// the connections in use and the wait duration, as go_sql_* metrics with the
+// db_name label. They are registered with the default registry served by
+// metricsfx.
+func RegisterPoolMetrics(lifecycle fx.Lifecycle, db *gorm.DB, config *PostgresConfig) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	name, err := config.databaseName()
+	if err != nil {
+		return err
+	}
+	return registerCollector(lifecycle, collectors.NewDBStatsCollector(sqlDB, name))
+}
+
+// databaseName returns the name of the database of the connection string,
+// which is set by the dsn or the structured fields.
+func (c *PostgresConfig) databaseName() (string, error) {
+	dsn, err := c.ConnString()
+	if err != nil {
+		return "", err
+	}
+	connConfig, err := pgconn.ParseConfig(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid postgres dsn: %w", err)
+	}
+	return connConfig.Database, nil
+}
+
+// registerCollector registers collector with the default registry until the
+// app stops. It fails if the metrics of another pool with the same label are
+// registered, e.g. by another app on the same database which is not stopped.
+func registerCollector(lifecycle fx.Lifecycle, collector prometheus.Collector) error {
+	if err := prometheus.Register(collector); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			return fmt.Errorf("metrics of the database pool are already registered: %w", err)
+		}
+		return err
+	}
+	lifecycle.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			prometheus.Unregister(collector)
+			return nil
+		},
+	})
+	return nil
+}
@@ -0,0 +1,68 @@
+package dbfx
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.uber.org/fx/fxtest"
+)
+
+func TestDatabaseName(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   PostgresConfig
+		expected string
+	}{
+		{"structured fields", PostgresConfig{UserName: "asta", Host: "localhost", Port: "5432", Database: "clinic"}, "clinic"},
+		{"dsn", PostgresConfig{Dsn: "postgres://asta@db:5432/records?sslmode=disable"}, "records"},
+		{"dsn over database", PostgresConfig{Dsn: "postgres://asta@db/records", Database: "clinic"}, "records"},
+	}
+	for _, test := range tests {
+		t.Run("Test "+test.name, func(t *testing.T) {
+			got, err := test.config.databaseName()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.expected {
+				t.Errorf("unexpected database name, got %q, expected %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestPoolMetrics(t *testing.T) {
+	t.Run("Test pool config", func(t *testing.T) {
+		db := sql.OpenDB(blockingConnector{})
+		defer db.Close()
+		PoolConfig{MaxOpenConns: 4, MaxIdleConns: 2}.apply(db)
+		if got := db.Stats().MaxOpenConnections; got != 4 {
+			t.Errorf("unexpected max open connections, got %d, expected 4", got)
+		}
+	})
+	t.Run("Test register collector", func(t *testing.T) {
+		db := sql.OpenDB(blockingConnector{})
+		defer db.Close()
+		lifecycle := fxtest.NewLifecycle(t)
+		if err := registerCollector(lifecycle, collectors.NewDBStatsCollector(db, "pool_test")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// another pool with the same label is not silently replaced
+		other := fxtest.NewLifecycle(t)
+		err := registerCollector(other, collectors.NewDBStatsCollector(db, "pool_test"))
+		var registered prometheus.AlreadyRegisteredError
+		if !errors.As(err, &registered) {
+			t.Fatalf("unexpected error, got %v, expected already registered error", err)
+		}
+
+		// the collector is unregistered when the app stops
+		lifecycle.RequireStart().RequireStop()
+		if err := registerCollector(other, collectors.NewDBStatsCollector(db, "pool_test")); err != nil {
+			t.Errorf("unexpected error after stop: %v", err)
+		}
+		other.RequireStart().RequireStop()
+	})
+}
@@ -0,0 +1,310 @@
+package dbfx
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+type ReplicaPolicy string
+
+var (
+	RandomReplicaPolicy       ReplicaPolicy = "random"
+	RoundRobinReplicaPolicy   ReplicaPolicy = "round_robin"
+	LeastLatencyReplicaPolicy ReplicaPolicy = "least_latency"
+)
+
+// ReplicaConfig is the read replicas of the database, which share the
+// credentials and the options of the primary. Reads are sent to the replicas,
+// writes and transactions to the primary.
+type ReplicaConfig struct {
+	Hosts  []string      `mapstructure:"hosts" yaml:"hosts" validate:"dive,hostname_port"`
+	Policy ReplicaPolicy `mapstructure:"policy" yaml:"policy" validate:"required,oneof=random round_robin least_latency"`
+	// HealthCheckInterval is the interval of the checks of the replicas,
+	// failing replicas are not used until they pass a check. The reads are
+	// sent to the primary while every replica fails
+	HealthCheckInterval time.Duration `mapstructure:"health_check_interval" yaml:"health_check_interval" validate:"required"`
+	// MaxLag is the replication lag of the replicas not used for reads, 0
+	// disables it
+	MaxLag time.Duration `mapstructure:"max_lag" yaml:"max_lag" validate:"min=0"`
+}
+
+func defaultReplicaConfig() ReplicaConfig {
+	return ReplicaConfig{
+		Policy:              RandomReplicaPolicy,
+		HealthCheckInterval: 10 * time.Second,
+	}
+}
+
+// replicaMetrics are the metrics of the health checks of the replicas.
+type replicaMetrics struct {
+	up      *prometheus.GaugeVec
+	lag     *prometheus.GaugeVec
+	latency *prometheus.GaugeVec
+}
+
+func newReplicaMetrics() *replicaMetrics {
+	return &replicaMetrics{
+		up: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "db_replica_up",
+			Help: "Whether the replica passed the last health check.",
+		}, []string{"replica"}),
+		lag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "db_replica_lag_seconds",
+			Help: "Replication lag of the replica at the last health check.",
+		}, []string{"replica"}),
+		latency: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "db_replica_ping_seconds",
+			Help: "Ping latency of the replica at the last health check.",
+		}, []string{"replica"}),
+	}
+}
+
+func (m *replicaMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.up.Describe(ch)
+	m.lag.Describe(ch)
+	m.latency.Describe(ch)
+}
+
+func (m *replicaMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.up.Collect(ch)
+	m.lag.Collect(ch)
+	m.latency.Collect(ch)
+}
+
+type replica struct {
+	host    string
+	db      *sql.DB
+	healthy atomic.Bool
+	latency atomic.Int64
+}
+
+// replicaSet checks the health of the replicas, and resolves the replica of
+// the reads by the policy among the healthy ones.
+type replicaSet struct {
+	config ReplicaConfig
+	// primary serves the reads when every replica fails, it is one of the
+	// pools resolved by the set
+	primary  gorm.ConnPool
+	replicas map[gorm.ConnPool]*replica
+	metrics  *replicaMetrics
+	next     atomic.Uint64
+}
+
+func (s *replicaSet) Resolve(pools []gorm.ConnPool) gorm.ConnPool {
+	healthy := make([]gorm.ConnPool, 0, len(pools))
+	for _, pool := range pools {
+		if r, ok := s.replicas[pool]; ok && r.healthy.Load() {
+			healthy = append(healthy, pool)
+		}
+	}
+	if len(healthy) == 0 {
+		return s.primary
+	}
+	switch s.config.Policy {
+	case RoundRobinReplicaPolicy:
+		return healthy[int(s.next.Add(1)%uint64(len(healthy)))]
+	case LeastLatencyReplicaPolicy:
+		fastest := healthy[0]
+		for _, pool := range healthy[1:] {
+			if r, ok := s.replicas[pool]; ok && r.latency.Load() < s.replicas[fastest].latency.Load() {
+				fastest = pool
+			}
+		}
+		return fastest
+	}
+	return healthy[rand.Intn(len(healthy))]
+}
+
+// check pings the replica and queries its replication lag.
+func (s *replicaSet) check(ctx context.Context, r *replica) error {
+	start := time.Now()
+	if err := r.db.PingContext(ctx); err != nil {
+		return err
+	}
+	latency := time.Since(start)
+	r.latency.Store(int64(latency))
+	s.metrics.latency.WithLabelValues(r.host).Set(latency.Seconds())
+
+	// the time since the last replayed transaction grows on an idle replica
+	// which replayed everything it received, whose lag is 0
+	var lag float64
+	if err := r.db.QueryRowContext(ctx, `SELECT CASE
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END`).Scan(&lag); err != nil {
+		return err
+	}
+	s.metrics.lag.WithLabelValues(r.host).Set(lag)
+	if s.config.MaxLag > 0 && lag > s.config.MaxLag.Seconds() {
+		return fmt.Errorf("replication lag %.3fs over %v", lag, s.config.MaxLag)
+	}
+	return nil
+}
+
+func (s *replicaSet) checkAll(ctx context.Context, logger *zap.SugaredLogger) {
+	var wg sync.WaitGroup
+	for _, r := range s.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, s.config.HealthCheckInterval)
+			defer cancel()
+			err := s.check(ctx, r)
+			if healthy := err == nil; r.healthy.Swap(healthy) != healthy {
+				if healthy {
+					logger.Infow("database replica recovered", "replica", r.host)
+				} else {
+					logger.Warnw("database replica failed health check", "replica", r.host, "err", err)
+				}
+			}
+			if err == nil {
+				s.metrics.up.WithLabelValues(r.host).Set(1)
+			} else {
+				s.metrics.up.WithLabelValues(r.host).Set(0)
+			}
+		}(r)
+	}
+	wg.Wait()
+}
+
+// replicaConnString returns the connection string of the replica on host,
+// with the credentials and options of the primary.
+func (c *PostgresConfig) replicaConnString(host string) (string, error) {
+	dsn, err := c.ConnString()
+	if err != nil {
+		return "", err
+	}
+	if !isURLDsn(dsn) {
+		settings, err := parseKeyValueDsn(dsn)
+		if err != nil {
+			return "", err
+		}
+		hostname, port, err := net.SplitHostPort(host)
+		if err != nil {
+			return "", fmt.Errorf("invalid replica host %q: %w", host, err)
+		}
+		settings["host"] = hostname
+		settings["port"] = port
+		// the address of the primary would be used instead of the host
+		delete(settings, "hostaddr")
+		return formatKeyValueDsn(settings), nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", err
+	}
+	u.Host = host
+	return u.String(), nil
+}
+
+// resolver returns the dbresolver sending the reads to the pools resolved by
+// the set. The primary is one of the pools, as dbresolver sends the reads to
+// a single replica without calling the policy.
+func (s *replicaSet) resolver(replicas []gorm.ConnPool) *dbresolver.DBResolver {
+	dialectors := make([]gorm.Dialector, 0, len(replicas)+1)
+	for _, pool := range replicas {
+		dialectors = append(dialectors, postgres.New(postgres.Config{Conn: pool}))
+	}
+	dialectors = append(dialectors, postgres.New(postgres.Config{Conn: s.primary}))
+	return dbresolver.Register(dbresolver.Config{
+		Replicas: dialectors,
+		Policy:   s,
+	})
+}
+
+// setupReplicas registers dbresolver to send the reads to the replicas, and
+// checks their health while the app runs.
+func setupReplicas(lifecycle fx.Lifecycle, db *gorm.DB, config *PostgresConfig, logger *zap.SugaredLogger) error {
+	if len(config.Replicas.Hosts) == 0 {
+		return nil
+	}
+	name, err := config.databaseName()
+	if err != nil {
+		return err
+	}
+	primary, err := db.DB()
+	if err != nil {
+		return err
+	}
+	set := &replicaSet{
+		config:   config.Replicas,
+		primary:  primary,
+		replicas: map[gorm.ConnPool]*replica{},
+		metrics:  newReplicaMetrics(),
+	}
+	if err := registerCollector(lifecycle, set.metrics); err != nil {
+		return err
+	}
+	replicas := make([]gorm.ConnPool, 0, len(config.Replicas.Hosts))
+	for _, host := range config.Replicas.Hosts {
+		dsn, err := config.replicaConnString(host)
+		if err != nil {
+			return err
+		}
+		connConfig, err := pgx.ParseConfig(dsn)
+		if err != nil {
+			return fmt.Errorf("invalid config of replica %s: %w", host, err)
+		}
+		sqlDB := stdlib.OpenDB(*connConfig)
+		config.Pool.apply(sqlDB)
+		r := &replica{host: host, db: sqlDB}
+		// replicas are used until the first health check
+		r.healthy.Store(true)
+		set.replicas[sqlDB] = r
+		replicas = append(replicas, sqlDB)
+		if err := registerCollector(lifecycle, collectors.NewDBStatsCollector(sqlDB, fmt.Sprintf("%s@%s", name, host))); err != nil {
+			return err
+		}
+	}
+	if err := db.Use(set.resolver(replicas)); err != nil {
+		return fmt.Errorf("fail to initialize database replicas: %w", err)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			set.checkAll(ctx, logger)
+			go func() {
+				defer close(stopped)
+				ticker := time.NewTicker(config.Replicas.HealthCheckInterval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						set.checkAll(context.Background(), logger)
+					case <-done:
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			close(done)
+			<-stopped
+			for _, r := range set.replicas {
+				r.db.Close()
+			}
+			return nil
+		},
+	})
+	return nil
+}
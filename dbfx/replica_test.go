@@ -0,0 +1,88 @@
+package dbfx
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestReplicaPolicy(t *testing.T) {
+	// the pools are never connected to
+	primary, a, b, c := &sql.DB{}, &sql.DB{}, &sql.DB{}, &sql.DB{}
+	set := &replicaSet{
+		config:  ReplicaConfig{Policy: LeastLatencyReplicaPolicy},
+		primary: primary,
+		replicas: map[gorm.ConnPool]*replica{
+			a: {host: "a:5432"},
+			b: {host: "b:5432"},
+			c: {host: "c:5432"},
+		},
+	}
+	pools := []gorm.ConnPool{a, b, c}
+	for pool, latency := range map[*sql.DB]time.Duration{a: 3 * time.Millisecond, b: time.Millisecond, c: 2 * time.Millisecond} {
+		set.replicas[pool].healthy.Store(true)
+		set.replicas[pool].latency.Store(int64(latency))
+	}
+
+	if got := set.Resolve(pools); got != b {
+		t.Errorf("unexpected replica, got %s, expected b:5432", set.replicas[got].host)
+	}
+	set.replicas[b].healthy.Store(false)
+	if got := set.Resolve(pools); got != c {
+		t.Errorf("unexpected replica, got %s, expected c:5432", set.replicas[got].host)
+	}
+
+	set.config.Policy = RoundRobinReplicaPolicy
+	seen := map[gorm.ConnPool]bool{}
+	for i := 0; i < 4; i++ {
+		seen[set.Resolve(pools)] = true
+	}
+	if len(seen) != 2 || seen[b] {
+		t.Errorf("unexpected replicas by round robin, got %v", seen)
+	}
+
+	for _, pool := range pools {
+		set.replicas[pool].healthy.Store(false)
+	}
+	if got := set.Resolve(pools); got != primary {
+		t.Errorf("unexpected pool without healthy replicas, got %v, expected the primary", got)
+	}
+}
+
+func TestReplicaResolver(t *testing.T) {
+	// the statements are built without being executed by the pools
+	primary, replicaDB := sql.OpenDB(blockingConnector{}), sql.OpenDB(blockingConnector{})
+	defer primary.Close()
+	defer replicaDB.Close()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: primary}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	set := &replicaSet{
+		config:   ReplicaConfig{Policy: RandomReplicaPolicy},
+		primary:  primary,
+		replicas: map[gorm.ConnPool]*replica{replicaDB: {host: "replica:5432", db: replicaDB}},
+	}
+	set.replicas[replicaDB].healthy.Store(true)
+	if err := db.Use(set.resolver([]gorm.ConnPool{replicaDB})); err != nil {
+		t.Fatalf("failed to register resolver: %v", err)
+	}
+
+	if got := db.Find(&[]patient{}).Statement.ConnPool; got != replicaDB {
+		t.Errorf("unexpected pool of read, got %v, expected the replica", got)
+	}
+	if got := db.Create(&patient{Name: "Jane Doe"}).Statement.ConnPool; got != primary {
+		t.Errorf("unexpected pool of write, got %v, expected the primary", got)
+	}
+	// a single replica is resolved by the set too
+	set.replicas[replicaDB].healthy.Store(false)
+	if got := db.Find(&[]patient{}).Statement.ConnPool; got != primary {
+		t.Errorf("unexpected pool of read without healthy replica, got %v, expected the primary", got)
+	}
+}
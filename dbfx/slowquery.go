@@ -0,0 +1,345 @@
+package dbfx
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/astaclinic/astafx/configfx"
+	"github.com/astaclinic/astafx/loggerfx"
+	"github.com/astaclinic/astafx/routerfx"
+)
+
+// SlowQuery is a statement slower than the slow threshold, the duration and
+// plan are of its slowest execution.
+type SlowQuery struct {
+	SQL      string          `json:"sql"`
+	Duration time.Duration   `json:"duration"`
+	Count    int             `json:"count"`
+	LastSeen time.Time       `json:"last_seen"`
+	Plan     json.RawMessage `json:"plan,omitempty"`
+}
+
+// SlowQueries keeps the slowest statements, up to the configured number of
+// distinct statements.
+type SlowQueries struct {
+	mu      sync.Mutex
+	size    int
+	queries map[string]*SlowQuery
+}
+
+// NewSlowQueries returns the slow queries kept up to the configured number,
+// which follows the reloads of the config.
+func NewSlowQueries(config *configfx.Reloadable[GormLoggerConfig]) *SlowQueries {
+	s := newSlowQueries(config.Load().SlowQueries)
+	config.Subscribe(func(_, new *GormLoggerConfig) {
+		s.resize(new.SlowQueries)
+	})
+	return s
+}
+
+func newSlowQueries(size int) *SlowQueries {
+	return &SlowQueries{
+		size:    size,
+		queries: map[string]*SlowQuery{},
+	}
+}
+
+// resize changes the number of statements kept, dropping the fastest ones.
+func (s *SlowQueries) resize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = size
+	for len(s.queries) > size && len(s.queries) > 0 {
+		delete(s.queries, s.fastest().SQL)
+	}
+}
+
+// fastest returns the fastest statement, s.mu must be held.
+func (s *SlowQueries) fastest() *SlowQuery {
+	var fastest *SlowQuery
+	for _, query := range s.queries {
+		if fastest == nil || query.Duration < fastest.Duration {
+			fastest = query
+		}
+	}
+	return fastest
+}
+
+func (s *SlowQueries) record(sql string, duration time.Duration) {
+	if s.size <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if query, ok := s.queries[sql]; ok {
+		query.Count++
+		query.LastSeen = now
+		if duration > query.Duration {
+			query.Duration = duration
+			// the plan is of the previous slowest execution
+			query.Plan = nil
+		}
+		return
+	}
+	if len(s.queries) >= s.size {
+		// replace the fastest statement if the new one is slower
+		fastest := s.fastest()
+		if duration <= fastest.Duration {
+			return
+		}
+		delete(s.queries, fastest.SQL)
+	}
+	s.queries[sql] = &SlowQuery{
+		SQL:      sql,
+		Duration: duration,
+		Count:    1,
+		LastSeen: now,
+	}
+}
+
+// setPlan sets the plan of the statement, if its slowest execution is still
+// the one explained.
+func (s *SlowQueries) setPlan(sql string, duration time.Duration, plan json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if query, ok := s.queries[sql]; ok && query.Duration == duration {
+		query.Plan = plan
+	}
+}
+
+// Get returns the slow queries, the slowest first.
+func (s *SlowQueries) Get() []SlowQuery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queries := make([]SlowQuery, 0, len(s.queries))
+	for _, query := range s.queries {
+		queries = append(queries, *query)
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].Duration > queries[j].Duration
+	})
+	return queries
+}
+
+// explainTimeout is the timeout of the EXPLAIN of a slow statement, which is
+// not canceled with the context of the statement.
+const explainTimeout = 5 * time.Second
+
+// explainQueueSize is the number of slow statements waiting for EXPLAIN,
+// the statements beyond it are not explained.
+const explainQueueSize = 16
+
+type explainJob struct {
+	logger *zap.SugaredLogger
+	// hub is the Sentry hub of the statement, nil without Sentry
+	hub       *sentry.Hub
+	sql       string
+	statement *capturedStatement
+	duration  time.Duration
+}
+
+// explainer runs the EXPLAIN of the slow statements in the background, so
+// the statements never wait for a connection of the pool.
+type explainer struct {
+	// db is set by the Initialize of the gorm logger
+	db   atomic.Pointer[sql.DB]
+	slow *SlowQueries
+	jobs chan explainJob
+}
+
+func newExplainer(lc fx.Lifecycle, slow *SlowQueries) *explainer {
+	e := &explainer{
+		slow: slow,
+		jobs: make(chan explainJob, explainQueueSize),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+				e.run(ctx)
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-stopCtx.Done():
+				return stopCtx.Err()
+			}
+		},
+	})
+	return e
+}
+
+// submit queues the job, it is dropped if the queue is full.
+func (e *explainer) submit(job explainJob) {
+	select {
+	case e.jobs <- job:
+	default:
+		job.logger.Debugw("Dropped EXPLAIN of slow SQL statement, the queue is full", "sql", job.sql)
+	}
+}
+
+func (e *explainer) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-e.jobs:
+			e.explain(ctx, job)
+		}
+	}
+}
+
+func (e *explainer) explain(ctx context.Context, job explainJob) {
+	db := e.db.Load()
+	if db == nil {
+		return
+	}
+	// the statements of the app come first, EXPLAIN is skipped rather than
+	// waiting for a connection
+	if stats := db.Stats(); stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections {
+		job.logger.Debugw("Skipped EXPLAIN of slow SQL statement, no connection is free", "sql", job.sql)
+		return
+	}
+	plan, err := explain(ctx, db, job.statement)
+	if err != nil {
+		job.logger.Warnw("Failed to explain slow SQL statement", "sql", job.sql, "explain_err", err)
+		return
+	}
+	e.slow.setPlan(job.sql, job.duration, plan)
+	addSlowQueryBreadcrumb(job.hub, job.sql, job.duration, plan)
+	job.logger.Warnw("Explained slow SQL statement", "sql", job.sql, "plan", plan)
+}
+
+// explain returns the plan of the statement in JSON by EXPLAIN, the
+// statement itself is not executed.
+func explain(ctx context.Context, db *sql.DB, statement *capturedStatement) (json.RawMessage, error) {
+	keyword := strings.ToUpper(strings.SplitN(strings.TrimSpace(statement.sql), " ", 2)[0])
+	switch keyword {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "WITH":
+	default:
+		return nil, fmt.Errorf("cannot explain %s statement", keyword)
+	}
+	ctx, cancel := context.WithTimeout(ctx, explainTimeout)
+	defer cancel()
+	var plan []byte
+	if err := db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+statement.sql, statement.vars...).Scan(&plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// addSlowQueryBreadcrumb records the slow statement, with its plan once it
+// is explained, for the Sentry events of hub.
+func addSlowQueryBreadcrumb(hub *sentry.Hub, sql string, duration time.Duration, plan json.RawMessage) {
+	if hub == nil {
+		return
+	}
+	data := map[string]interface{}{
+		"duration_ms": float64(duration.Nanoseconds()) / 1e6,
+	}
+	if plan != nil {
+		data["plan"] = plan
+	}
+	hub.AddBreadcrumb(&sentry.Breadcrumb{
+		Type:     "query",
+		Category: "db.slow_query",
+		Message:  sql,
+		Data:     data,
+		Level:    sentry.LevelWarning,
+	}, nil)
+}
+
+// SlowQueryHandler reports the slowest statements as JSON over HTTP. It
+// requires the configured slow queries token as a bearer token, and is
+// disabled if no token is configured. The literals of the statements and
+// their plans are masked by the redaction of the logs.
+type SlowQueryHandler struct {
+	queries  *SlowQueries
+	config   *configfx.Reloadable[GormLoggerConfig]
+	redactor *loggerfx.Redactor
+}
+
+func NewSlowQueryHandler(queries *SlowQueries, config *configfx.Reloadable[GormLoggerConfig], redactor *loggerfx.Redactor) *SlowQueryHandler {
+	return &SlowQueryHandler{queries, config, redactor}
+}
+
+func (sh *SlowQueryHandler) HttpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token := sh.config.Load().SlowQueriesToken
+		if token == "" {
+			http.Error(w, "the slow queries are disabled", http.StatusForbidden)
+			return
+		}
+		if !routerfx.AuthorizeBearer(w, r, token) {
+			return
+		}
+		queries := sh.queries.Get()
+		for i := range queries {
+			queries[i].SQL = sh.redactor.RedactSQL(queries[i].SQL)
+			queries[i].Plan = sh.redactPlan(queries[i].Plan)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(queries)
+	})
+}
+
+// redactPlan masks the literals in the strings of the plan, such as the
+// conditions of the nodes.
+func (sh *SlowQueryHandler) redactPlan(plan json.RawMessage) json.RawMessage {
+	if plan == nil {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(plan, &value); err != nil {
+		return nil
+	}
+	redacted, err := json.Marshal(sh.redactValue(value))
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+func (sh *SlowQueryHandler) redactValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return sh.redactor.RedactSQL(value)
+	case map[string]interface{}:
+		for key, v := range value {
+			value[key] = sh.redactValue(v)
+		}
+	case []interface{}:
+		for i, v := range value {
+			value[i] = sh.redactValue(v)
+		}
+	}
+	return value
+}
+
+func (sh *SlowQueryHandler) RoutePattern() string {
+	return "/db/slow-queries"
+}
@@ -0,0 +1,157 @@
+package dbfx
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/astaclinic/astafx/loggerfx"
+)
+
+func TestSlowQueries(t *testing.T) {
+	queries := newSlowQueries(2)
+	queries.record("SELECT 1", 2*time.Second)
+	queries.record("SELECT 2", 3*time.Second)
+	queries.record("SELECT 1", 4*time.Second)
+	queries.record("SELECT 3", time.Second)
+	queries.record("SELECT 4", 5*time.Second)
+
+	got := queries.Get()
+	if len(got) != 2 || got[0].SQL != "SELECT 4" || got[1].SQL != "SELECT 1" {
+		t.Fatalf("unexpected slow queries, got %+v", got)
+	}
+	if got[1].Count != 2 || got[1].Duration != 4*time.Second {
+		t.Errorf("unexpected slow query of repeated statement, got %+v", got[1])
+	}
+
+	queries.resize(1)
+	if got := queries.Get(); len(got) != 1 || got[0].SQL != "SELECT 4" {
+		t.Errorf("unexpected slow queries after resize, got %+v", got)
+	}
+}
+
+// blockingConnector opens connections failing every statement, to hold the
+// connections of a pool.
+type blockingConnector struct{}
+
+func (blockingConnector) Connect(context.Context) (driver.Conn, error) {
+	return blockingConn{}, nil
+}
+
+func (c blockingConnector) Driver() driver.Driver {
+	return nil
+}
+
+type blockingConn struct{}
+
+func (blockingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("unexpected statement")
+}
+
+func (blockingConn) Close() error {
+	return nil
+}
+
+func (blockingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("unexpected transaction")
+}
+
+func TestExplainer(t *testing.T) {
+	t.Run("Test saturated pool", func(t *testing.T) {
+		db := sql.OpenDB(blockingConnector{})
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("failed to hold connection: %v", err)
+		}
+		defer conn.Close()
+
+		core, logs := observer.New(zap.DebugLevel)
+		e := newExplainer(fxtest.NewLifecycle(t), newSlowQueries(1))
+		e.db.Store(db)
+		// the statement would wait for the held connection without the check
+		e.explain(context.Background(), explainJob{
+			logger:    zap.New(core).Sugar(),
+			sql:       "SELECT 1",
+			statement: &capturedStatement{sql: "SELECT 1"},
+			duration:  time.Second,
+		})
+		if entries := logs.FilterMessageSnippet("Skipped EXPLAIN").TakeAll(); len(entries) != 1 {
+			t.Errorf("unexpected entries, got %v", logs.All())
+		}
+	})
+	t.Run("Test plan of slowest execution", func(t *testing.T) {
+		queries := newSlowQueries(1)
+		queries.record("SELECT 1", time.Second)
+		queries.record("SELECT 1", 2*time.Second)
+		queries.setPlan("SELECT 1", time.Second, json.RawMessage(`[]`))
+		if got := queries.Get(); got[0].Plan != nil {
+			t.Errorf("unexpected plan of previous execution, got %s", got[0].Plan)
+		}
+		queries.setPlan("SELECT 1", 2*time.Second, json.RawMessage(`[]`))
+		if got := queries.Get(); string(got[0].Plan) != `[]` {
+			t.Errorf("unexpected plan, got %s", got[0].Plan)
+		}
+	})
+}
+
+func TestSlowQueryHandler(t *testing.T) {
+	const query = `SELECT * FROM "patients" WHERE name = 'Jane Doe'`
+	serve := func(t *testing.T, db *dryRunDB, authorization string) *httptest.ResponseRecorder {
+		t.Helper()
+		redactor, err := loggerfx.NewRedactor(loggerfx.DefaultRedactConfig())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/db/slow-queries", nil)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		NewSlowQueryHandler(db.slow, db.config, redactor).HttpHandler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Test disabled without token", func(t *testing.T) {
+		db := newDryRunDB(t)
+		if rec := serve(t, db, ""); rec.Code != http.StatusForbidden {
+			t.Errorf("unexpected status, got %d, expected %d", rec.Code, http.StatusForbidden)
+		}
+	})
+	t.Run("Test token and redaction", func(t *testing.T) {
+		t.Setenv("ASTA_GORM_SLOW_QUERIES_TOKEN", "s3cr3t")
+		db := newDryRunDB(t)
+		db.slow.record(query, time.Second)
+		db.slow.setPlan(query, time.Second, json.RawMessage(`[{"Plan":{"Node Type":"Seq Scan","Filter":"(name = 'Jane Doe'::text)"}}]`))
+
+		for _, authorization := range []string{"", "Bearer wrong"} {
+			if rec := serve(t, db, authorization); rec.Code != http.StatusUnauthorized {
+				t.Errorf("unexpected status for authorization %q, got %d, expected %d", authorization, rec.Code, http.StatusUnauthorized)
+			}
+		}
+		rec := serve(t, db, "Bearer s3cr3t")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status, got %d, expected %d", rec.Code, http.StatusOK)
+		}
+		var queries []SlowQuery
+		if err := json.NewDecoder(rec.Body).Decode(&queries); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		if len(queries) != 1 || strings.Contains(queries[0].SQL, "Jane Doe") || strings.Contains(string(queries[0].Plan), "Jane Doe") {
+			t.Errorf("unexpected slow queries, got %+v", queries)
+		}
+		if !strings.Contains(string(queries[0].Plan), "Seq Scan") {
+			t.Errorf("unexpected redaction of plan, got %s", queries[0].Plan)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package dbfx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+
+	"github.com/astaclinic/astafx/retry"
+)
+
+type IsolationLevel string
+
+var (
+	DefaultIsolation        IsolationLevel = "default"
+	ReadCommittedIsolation  IsolationLevel = "read_committed"
+	RepeatableReadIsolation IsolationLevel = "repeatable_read"
+	SerializableIsolation   IsolationLevel = "serializable"
+)
+
+var isolationLevelMap = map[IsolationLevel]sql.IsolationLevel{
+	DefaultIsolation:        sql.LevelDefault,
+	ReadCommittedIsolation:  sql.LevelReadCommitted,
+	RepeatableReadIsolation: sql.LevelRepeatableRead,
+	SerializableIsolation:   sql.LevelSerializable,
+}
+
+type TxConfig struct {
+	Isolation IsolationLevel `mapstructure:"isolation" yaml:"isolation" validate:"required,oneof=default read_committed repeatable_read serializable"`
+	// MaxRetries is the number of retries of the transactions failed by a
+	// serialization failure or a deadlock
+	MaxRetries   int           `mapstructure:"max_retries" yaml:"max_retries" validate:"min=0"`
+	RetryBackoff time.Duration `mapstructure:"retry_backoff" yaml:"retry_backoff" validate:"required"`
+	MaxBackoff   time.Duration `mapstructure:"max_backoff" yaml:"max_backoff" validate:"required,gtefield=RetryBackoff"`
+}
+
+func defaultTxConfig() TxConfig {
+	return TxConfig{
+		Isolation:    DefaultIsolation,
+		MaxRetries:   3,
+		RetryBackoff: 50 * time.Millisecond,
+		MaxBackoff:   time.Second,
+	}
+}
+
+// TxOption changes the options of a transaction from the config.
+type TxOption func(*sql.TxOptions)
+
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(opts *sql.TxOptions) {
+		opts.Isolation = level
+	}
+}
+
+func ReadOnly() TxOption {
+	return func(opts *sql.TxOptions) {
+		opts.ReadOnly = true
+	}
+}
+
+type txKey struct{}
+
+// TxManager runs transactions with the active transaction stored in the
+// context, so the repositories called by fn share it by DB(ctx).
+type TxManager struct {
+	db     *gorm.DB
+	config TxConfig
+}
+
+func NewTxManager(db *gorm.DB, config *PostgresConfig) *TxManager {
+	return &TxManager{db, config.Tx}
+}
+
+// DB returns the active transaction of ctx, or the database if there is none,
+// with ctx set as the context of the statements.
+func (m *TxManager) DB(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return m.db.WithContext(ctx)
+}
+
+// WithTx runs fn in a transaction, committed if fn returns nil. If ctx has an
+// active transaction, fn runs in a savepoint of it, rolled back if fn fails.
+//
+// Transactions failed by a serialization failure or a deadlock are retried
+// with backoff, so fn must be safe to run again. Nested calls are not retried
+// on their own, as the error aborts the outermost transaction.
+func (m *TxManager) WithTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx).Transaction(func(nested *gorm.DB) error {
+			return fn(context.WithValue(ctx, txKey{}, nested))
+		})
+	}
+
+	txOptions := &sql.TxOptions{Isolation: isolationLevelMap[m.config.Isolation]}
+	for _, opt := range opts {
+		opt(txOptions)
+	}
+	for attempt := 0; ; attempt++ {
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(context.WithValue(ctx, txKey{}, tx))
+		}, txOptions)
+		if err == nil || attempt >= m.config.MaxRetries || !IsRetryable(err) {
+			return err
+		}
+		timer := time.NewTimer(retry.Backoff(m.config.RetryBackoff, m.config.MaxBackoff, attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// postgres error codes of the transactions which may succeed if retried
+const (
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+// IsRetryable reports whether err is a serialization failure or a deadlock.
+func IsRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == serializationFailure || pgErr.Code == deadlockDetected
+}
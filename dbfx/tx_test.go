@@ -0,0 +1,23 @@
+package dbfx
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+)
+
+func TestTxRetry(t *testing.T) {
+	t.Run("Test retryable errors", func(t *testing.T) {
+		for code, expected := range map[string]bool{"40001": true, "40P01": true, "23505": false} {
+			err := fmt.Errorf("error in transaction: %w", &pgconn.PgError{Code: code})
+			if got := IsRetryable(err); got != expected {
+				t.Errorf("unexpected retryable of %s, got %v, expected %v", code, got, expected)
+			}
+		}
+		if IsRetryable(errors.New("connection refused")) {
+			t.Errorf("unexpected retryable of other errors")
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package grpcfx
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/astaclinic/astafx/logger"
+)
+
+// RequestIDMetadata is the metadata key of the request ID, which is generated
+// for calls without one and returned in the response header.
+const RequestIDMetadata = "x-request-id"
+
+// callLogger returns the context of a call carrying a logger with the request
+// ID, method and peer fields, and the request ID.
+func callLogger(ctx context.Context, base *zap.SugaredLogger, method string) (context.Context, string) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDMetadata); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if id == "" {
+		id = logger.NewRequestID()
+	}
+	fields := []any{"request_id", id, "route", method}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields = append(fields, "peer", p.Addr.String())
+	}
+	return logger.NewContext(ctx, base.With(fields...)), id
+}
+
+// UnaryLoggerInterceptor adds the logger of the call to the context of unary
+// handlers.
+func UnaryLoggerInterceptor(base *zap.SugaredLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, id := callLogger(ctx, base, info.FullMethod)
+		if err := grpc.SetHeader(ctx, metadata.Pairs(RequestIDMetadata, id)); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamLoggerInterceptor adds the logger of the call to the context of
+// streaming handlers.
+func StreamLoggerInterceptor(base *zap.SugaredLogger) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, id := callLogger(stream.Context(), base, info.FullMethod)
+		if err := stream.SetHeader(metadata.Pairs(RequestIDMetadata, id)); err != nil {
+			return err
+		}
+		return handler(srv, &loggerStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+type loggerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *loggerStream) Context() context.Context {
+	return s.ctx
+}
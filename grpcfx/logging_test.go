@@ -0,0 +1,117 @@
+package grpcfx_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/astaclinic/astafx/grpcfx"
+	"github.com/astaclinic/astafx/logger"
+)
+
+// fakeTransportStream records the headers set by the unary interceptor.
+type fakeTransportStream struct {
+	header metadata.MD
+}
+
+func (s *fakeTransportStream) Method() string {
+	return "/test.Service/Method"
+}
+
+func (s *fakeTransportStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *fakeTransportStream) SendHeader(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+func (s *fakeTransportStream) SetTrailer(md metadata.MD) error {
+	return nil
+}
+
+// fakeServerStream records the headers set by the stream interceptor.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func incomingContext(id string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}})
+	if id == "" {
+		return ctx
+	}
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(grpcfx.RequestIDMetadata, id))
+}
+
+func TestLoggerInterceptors(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	base := zap.New(core).Sugar()
+	handle := func(ctx context.Context) {
+		logger.FromContext(ctx, zap.NewNop().Sugar()).Info("handled")
+	}
+
+	t.Run("Test unary interceptor", func(t *testing.T) {
+		stream := &fakeTransportStream{}
+		ctx := grpc.NewContextWithServerTransportStream(incomingContext("abc"), stream)
+		info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+		_, err := grpcfx.UnaryLoggerInterceptor(base)(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+			handle(ctx)
+			return nil, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := stream.header.Get(grpcfx.RequestIDMetadata); len(got) != 1 || got[0] != "abc" {
+			t.Errorf("unexpected request ID header, got %v, expected abc", got)
+		}
+		entries := logs.TakeAll()
+		if len(entries) != 1 {
+			t.Fatalf("unexpected entries of the handler, got %v", entries)
+		}
+		fields := entries[0].ContextMap()
+		if fields["request_id"] != "abc" || fields["route"] != info.FullMethod || fields["peer"] != "10.0.0.1:1234" {
+			t.Errorf("unexpected fields of the call logger, got %v", fields)
+		}
+	})
+	t.Run("Test stream interceptor", func(t *testing.T) {
+		stream := &fakeServerStream{ctx: incomingContext("")}
+		info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}
+		err := grpcfx.StreamLoggerInterceptor(base)(nil, stream, info, func(srv any, stream grpc.ServerStream) error {
+			handle(stream.Context())
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ids := stream.header.Get(grpcfx.RequestIDMetadata)
+		if len(ids) != 1 || len(ids[0]) != 32 {
+			t.Fatalf("unexpected generated request ID, got %v", ids)
+		}
+		entries := logs.TakeAll()
+		if len(entries) != 1 {
+			t.Fatalf("unexpected entries of the handler, got %v", entries)
+		}
+		fields := entries[0].ContextMap()
+		if fields["request_id"] != ids[0] || fields["route"] != info.FullMethod {
+			t.Errorf("unexpected fields of the call logger, got %v", fields)
+		}
+	})
+}
@@ -4,14 +4,19 @@ import (
 	"context"
 	"net"
 
-	"github.com/spf13/viper"
 	"go.uber.org/fx"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/reflection"
+
+	"github.com/astaclinic/astafx/configfx"
 )
 
 var Module = fx.Module("grpc",
+	configfx.Provide("grpc", GrpcConfig{
+		ListenAddr: ":50051",
+	}),
 	fx.Provide(NewGrpcServer),
 	fx.Provide(health.NewServer), // Add health check
 	fx.Invoke(RunGrpcServer),
@@ -22,14 +27,20 @@ type GrpcConfig struct {
 	ListenAddr string `mapstructure:"listen_addr" yaml:"listen_addr" validate:"required,hostname_port"`
 }
 
-func init() {
-	// config must have a default value for viper to load config from env variables
-	// default value of empty string (zero value) will not pass the "required" config validation
-	viper.SetDefault("grpc.listen_addr", ":50051")
+type NewGrpcServerParams struct {
+	fx.In
+	Logger *zap.SugaredLogger `optional:"true"`
 }
 
-func NewGrpcServer() *grpc.Server {
-	ser := grpc.NewServer()
+func NewGrpcServer(p NewGrpcServerParams) *grpc.Server {
+	var opts []grpc.ServerOption
+	if p.Logger != nil {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(UnaryLoggerInterceptor(p.Logger)),
+			grpc.ChainStreamInterceptor(StreamLoggerInterceptor(p.Logger)),
+		)
+	}
+	ser := grpc.NewServer(opts...)
 	reflection.Register(ser) // Enable reflection
 	return ser
 }
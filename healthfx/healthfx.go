@@ -0,0 +1,346 @@
+package healthfx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/astaclinic/astafx/configfx"
+	"github.com/astaclinic/astafx/routerfx"
+)
+
+var Module = fx.Options(
+	fx.Module("health",
+		configfx.Provide("health", HealthConfig{
+			Interval:   10 * time.Second,
+			Timeout:    5 * time.Second,
+			DrainDelay: 5 * time.Second,
+		}),
+		fx.Provide(NewEvaluator),
+		fx.Provide(routerfx.AsHandlerRoute(NewLivenessHandler)),
+		fx.Provide(routerfx.AsHandlerRoute(NewReadinessHandler)),
+		fx.Invoke(RunEvaluator),
+	),
+	// fx runs the invokes of the modules before the ones of the app, so the
+	// hook of the drain is appended after the hooks of the servers of the
+	// httpfx and grpcfx modules and stops before them
+	fx.Invoke(DrainOnStop),
+)
+
+type HealthConfig struct {
+	// Interval is the interval of the checks, the handlers report the result
+	// of the last checks
+	Interval time.Duration `mapstructure:"interval" yaml:"interval" validate:"required"`
+	Timeout  time.Duration `mapstructure:"timeout" yaml:"timeout" validate:"required"`
+	// DrainDelay is how long the app keeps serving after it reports that it
+	// is not ready when it stops, so that the load balancers see the status
+	// before the servers close their listeners. It is cut short by the stop
+	// timeout of the app, which must leave time for the servers to stop.
+	DrainDelay time.Duration `mapstructure:"drain_delay" yaml:"drain_delay" validate:"min=0"`
+}
+
+// Checker checks the health of a component the app depends on, the app is
+// not ready while a check fails.
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+// AsChecker annotates a constructor of a Checker to contribute it to the
+// readiness of the app.
+func AsChecker(checker any) any {
+	return fx.Annotate(
+		checker,
+		fx.As(new(Checker)),
+		fx.ResultTags(`group:"healthCheckers"`),
+	)
+}
+
+// FuncChecker is a Checker calling a function.
+type FuncChecker struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+func NewChecker(name string, check func(ctx context.Context) error) *FuncChecker {
+	return &FuncChecker{name, check}
+}
+
+func (c *FuncChecker) Name() string {
+	return c.name
+}
+
+func (c *FuncChecker) Check(ctx context.Context) error {
+	return c.check(ctx)
+}
+
+type Status string
+
+var (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+)
+
+type CheckResult struct {
+	Status    Status        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+type Report struct {
+	Status Status                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Evaluator runs the checks in the background and keeps the last results.
+type Evaluator struct {
+	config   HealthConfig
+	checkers []Checker
+	logger   *zap.SugaredLogger
+
+	mu      sync.RWMutex
+	results map[string]CheckResult
+	// ready is false until the first checks
+	ready bool
+	// draining is set when the app stops, the app is not ready anymore
+	draining bool
+}
+
+type EvaluatorParams struct {
+	fx.In
+	Config   *HealthConfig
+	Checkers []Checker `group:"healthCheckers"`
+	Logger   *zap.SugaredLogger
+}
+
+func NewEvaluator(p EvaluatorParams) *Evaluator {
+	return &Evaluator{
+		config:   *p.Config,
+		checkers: p.Checkers,
+		logger:   p.Logger,
+		results:  map[string]CheckResult{},
+	}
+}
+
+// Evaluate runs every check in parallel and updates the results.
+func (e *Evaluator) Evaluate(ctx context.Context) Report {
+	results := make(map[string]CheckResult, len(e.checkers))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, checker := range e.checkers {
+		wg.Add(1)
+		go func(checker Checker) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+			defer cancel()
+			start := time.Now()
+			err := checker.Check(ctx)
+			result := CheckResult{Status: StatusUp, Duration: time.Since(start), CheckedAt: start}
+			if err != nil {
+				result.Status = StatusDown
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			results[checker.Name()] = result
+			mu.Unlock()
+		}(checker)
+	}
+	wg.Wait()
+
+	e.mu.Lock()
+	for name, result := range results {
+		previous, ok := e.results[name]
+		switch {
+		case result.Status != StatusUp && (!ok || previous.Status == StatusUp):
+			// the details of the errors are logged rather than reported by
+			// the readiness handler
+			e.logger.Warnw("health check failed", "check", name, "err", result.Error)
+		case result.Status == StatusUp && ok && previous.Status != StatusUp:
+			e.logger.Infow("health check recovered", "check", name)
+		}
+	}
+	e.results = results
+	e.ready = true
+	e.mu.Unlock()
+	return e.Report()
+}
+
+// Report returns the results of the last checks, the status is up if the app
+// is ready and every check passed.
+func (e *Evaluator) Report() Report {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	report := Report{Status: StatusUp, Checks: make(map[string]CheckResult, len(e.results))}
+	if !e.ready || e.draining {
+		report.Status = StatusDown
+	}
+	for name, result := range e.results {
+		report.Checks[name] = result
+		if result.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+// drain reports the app as not ready until it stops, regardless of the
+// checks.
+func (e *Evaluator) drain() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.draining = true
+}
+
+type RunEvaluatorParams struct {
+	fx.In
+	Lifecycle  fx.Lifecycle
+	Evaluator  *Evaluator
+	GrpcServer *grpc.Server   `optional:"true"`
+	GrpcHealth *health.Server `optional:"true"`
+}
+
+// RunEvaluator runs the checks while the app runs, and sets the serving status
+// of every service of the gRPC server to the overall status.
+func RunEvaluator(p RunEvaluatorParams) {
+	setServingStatus := func(report Report) {
+		if p.GrpcHealth == nil {
+			return
+		}
+		status := grpc_health_v1.HealthCheckResponse_SERVING
+		if report.Status != StatusUp {
+			status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+		}
+		p.GrpcHealth.SetServingStatus("", status)
+		if p.GrpcServer != nil {
+			for service := range p.GrpcServer.GetServiceInfo() {
+				p.GrpcHealth.SetServingStatus(service, status)
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			// the app is ready by the first checks, which do not fail the start
+			setServingStatus(p.Evaluator.Evaluate(ctx))
+			go func() {
+				defer close(stopped)
+				ticker := time.NewTicker(p.Evaluator.config.Interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						setServingStatus(p.Evaluator.Evaluate(context.Background()))
+					case <-done:
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			close(done)
+			<-stopped
+			return nil
+		},
+	})
+}
+
+type DrainParams struct {
+	fx.In
+	Lifecycle  fx.Lifecycle
+	Config     *HealthConfig
+	Evaluator  *Evaluator
+	GrpcHealth *health.Server `optional:"true"`
+}
+
+// DrainOnStop reports the app as not ready when it stops and waits for the
+// configured drain delay, or until the stop context is done, so the load
+// balancers stop sending traffic before the servers close their listeners.
+// Its hook must be appended after the hooks of the servers, which Module does.
+func DrainOnStop(p DrainParams) {
+	p.Lifecycle.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			p.Evaluator.drain()
+			// the serving status is NOT_SERVING from now on, the later
+			// updates are ignored
+			if p.GrpcHealth != nil {
+				p.GrpcHealth.Shutdown()
+			}
+			if p.Config.DrainDelay <= 0 {
+				return nil
+			}
+			timer := time.NewTimer(p.Config.DrainDelay)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	})
+}
+
+// LivenessHandler reports that the app is running, regardless of the checks.
+type LivenessHandler struct{}
+
+func NewLivenessHandler() *LivenessHandler {
+	return &LivenessHandler{}
+}
+
+func (lh *LivenessHandler) HttpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]Status{"status": StatusUp})
+	})
+}
+
+func (lh *LivenessHandler) RoutePattern() string {
+	return "/healthz"
+}
+
+// ReadinessHandler reports the status of the last checks, with the status
+// 503 if the app is not ready. The errors of the checks are only logged, as
+// they may expose the addresses or credentials of the dependencies.
+type ReadinessHandler struct {
+	evaluator *Evaluator
+}
+
+func NewReadinessHandler(evaluator *Evaluator) *ReadinessHandler {
+	return &ReadinessHandler{evaluator}
+}
+
+func (rh *ReadinessHandler) HttpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := rh.evaluator.Report()
+		checks := make(map[string]Status, len(report.Checks))
+		for name, result := range report.Checks {
+			checks[name] = result.Status
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != StatusUp {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(readiness{Status: report.Status, Checks: checks})
+	})
+}
+
+// readiness is the response of the readiness handler.
+type readiness struct {
+	Status Status            `json:"status"`
+	Checks map[string]Status `json:"checks"`
+}
+
+func (rh *ReadinessHandler) RoutePattern() string {
+	return "/readyz"
+}
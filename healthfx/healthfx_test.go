@@ -0,0 +1,152 @@
+package healthfx_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/fx"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/astaclinic/astafx/config"
+	"github.com/astaclinic/astafx/configfx"
+	"github.com/astaclinic/astafx/healthfx"
+	"github.com/astaclinic/astafx/loggerfx"
+)
+
+func TestHealth(t *testing.T) {
+	t.Setenv("ASTA_LOGS_PATH", t.TempDir())
+	t.Setenv("ASTA_HEALTH_DRAIN_DELAY", "0s")
+	var failing error
+	var evaluator *healthfx.Evaluator
+	grpcHealth := health.NewServer()
+	app := fx.New(
+		fx.NopLogger,
+		fx.Supply(config.Options{EnvPrefix: "asta"}),
+		configfx.Module,
+		loggerfx.Module,
+		healthfx.Module,
+		fx.Supply(grpcHealth),
+		fx.Provide(healthfx.AsChecker(func() *healthfx.FuncChecker {
+			return healthfx.NewChecker("ok", func(context.Context) error { return nil })
+		})),
+		fx.Provide(healthfx.AsChecker(func() *healthfx.FuncChecker {
+			return healthfx.NewChecker("flaky", func(context.Context) error { return failing })
+		})),
+		fx.Populate(&evaluator),
+	)
+	if err := app.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	readiness := healthfx.NewReadinessHandler(evaluator).HttpHandler()
+	ready := func() (int, string) {
+		rec := httptest.NewRecorder()
+		readiness.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, _ := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status before start, got %d", code)
+	}
+	ctx := context.Background()
+	if err := app.Start(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer app.Stop(ctx)
+	if code, body := ready(); code != http.StatusOK || !strings.Contains(body, `"flaky":"up"`) {
+		t.Errorf("unexpected readiness, got %d %s", code, body)
+	}
+	res, err := grpcHealth.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil || res.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Errorf("unexpected gRPC health, got %v %v", res, err)
+	}
+
+	failing = errors.New("connection refused")
+	evaluator.Evaluate(ctx)
+	if code, body := ready(); code != http.StatusServiceUnavailable || !strings.Contains(body, `"flaky":"down"`) {
+		t.Errorf("unexpected readiness of failed check, got %d %s", code, body)
+	}
+	// the errors are logged but not reported
+	if _, body := ready(); strings.Contains(body, "connection refused") {
+		t.Errorf("unexpected error in readiness, got %s", body)
+	}
+	rec := httptest.NewRecorder()
+	healthfx.NewLivenessHandler().HttpHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("unexpected liveness, got %d", rec.Code)
+	}
+}
+
+func TestDrainOnStop(t *testing.T) {
+	t.Setenv("ASTA_LOGS_PATH", t.TempDir())
+	newApp := func(t *testing.T, drainDelay string) (*fx.App, *health.Server, func() (healthfx.Status, time.Time)) {
+		t.Setenv("ASTA_HEALTH_DRAIN_DELAY", drainDelay)
+		var evaluator *healthfx.Evaluator
+		var readyOnServerStop healthfx.Status
+		var serverStopped time.Time
+		grpcHealth := health.NewServer()
+		app := fx.New(
+			fx.NopLogger,
+			fx.Supply(config.Options{EnvPrefix: "asta"}),
+			configfx.Module,
+			loggerfx.Module,
+			healthfx.Module,
+			fx.Supply(grpcHealth),
+			// a server declared after the health module, like httpfx
+			fx.Module("server", fx.Invoke(func(lifecycle fx.Lifecycle, evaluator *healthfx.Evaluator) {
+				lifecycle.Append(fx.Hook{
+					OnStop: func(context.Context) error {
+						readyOnServerStop = evaluator.Report().Status
+						serverStopped = time.Now()
+						return nil
+					},
+				})
+			})),
+			fx.Populate(&evaluator),
+		)
+		if err := app.Start(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := evaluator.Report().Status; got != healthfx.StatusUp {
+			t.Fatalf("unexpected status after start, got %s", got)
+		}
+		return app, grpcHealth, func() (healthfx.Status, time.Time) {
+			return readyOnServerStop, serverStopped
+		}
+	}
+
+	t.Run("Test servers stop after the drain delay", func(t *testing.T) {
+		app, grpcHealth, serverStop := newApp(t, "100ms")
+		ctx := context.Background()
+		stopping := time.Now()
+		if err := app.Stop(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ready, stopped := serverStop()
+		if ready != healthfx.StatusDown {
+			t.Errorf("unexpected status when the server stops, got %s, expected %s", ready, healthfx.StatusDown)
+		}
+		if elapsed := stopped.Sub(stopping); elapsed < 100*time.Millisecond {
+			t.Errorf("server stopped %s after the app started stopping, expected the drain delay of 100ms", elapsed)
+		}
+		res, err := grpcHealth.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		if err != nil || res.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("unexpected gRPC health after stop, got %v %v", res, err)
+		}
+	})
+	t.Run("Test drain delay is bounded by the stop context", func(t *testing.T) {
+		app, _, _ := newApp(t, "1h")
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		stopping := time.Now()
+		app.Stop(ctx)
+		if elapsed := time.Since(stopping); elapsed > 10*time.Second {
+			t.Errorf("stop took %s, expected it to end with the stop context", elapsed)
+		}
+	})
+}
@@ -5,23 +5,21 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/spf13/viper"
 	"go.uber.org/fx"
+
+	"github.com/astaclinic/astafx/configfx"
 )
 
 var Module = fx.Module("http",
+	configfx.Provide("http", HttpConfig{
+		ListenAddr: ":8080",
+	}),
 	fx.Provide(NewHttp),
 	fx.Invoke(RunHttpServer),
 )
 
 type HttpConfig struct {
-	ListenAddr string `mapstructure:"listen_addr" yaml:"listen_addr" required:"required,hostname_port"`
-}
-
-func init() {
-	// config must have a default value for viper to load config from env variables
-	// default value of empty string (zero value) will not pass the "required" config validation
-	viper.SetDefault("http.listen_addr", ":8080")
+	ListenAddr string `mapstructure:"listen_addr" yaml:"listen_addr" validate:"required,hostname_port"`
 }
 
 type HttpParams struct {
@@ -0,0 +1,57 @@
+package logger
+
+import (
+	"io"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewConsoleEncoder returns the encoder of the console format, with the levels
+// colored as [INFO] if colored is set.
+func NewConsoleEncoder(colored bool) zapcore.Encoder {
+	colorMap := map[zapcore.Level]*color.Color{
+		zapcore.DebugLevel:  DebugColor,
+		zapcore.InfoLevel:   InfoColor,
+		zapcore.WarnLevel:   WarnColor,
+		zapcore.ErrorLevel:  ErrorColor,
+		zapcore.DPanicLevel: FatalColor,
+		zapcore.FatalLevel:  FatalColor,
+		zapcore.PanicLevel:  FatalColor,
+	}
+	for l, c := range colorMap {
+		// copy the shared colors to decide on the coloring for this encoder only
+		levelColor := *c
+		if colored {
+			levelColor.EnableColor()
+		} else {
+			levelColor.DisableColor()
+		}
+		colorMap[l] = &levelColor
+	}
+
+	consoleEncoderConfig := zap.NewProductionEncoderConfig()
+	consoleEncoderConfig.EncodeLevel = func(l zapcore.Level, pae zapcore.PrimitiveArrayEncoder) {
+		// custom encoding of level string as [INFO] style
+		pae.AppendString(colorMap[l].Sprintf("[%s]", l.CapitalString()))
+	}
+	consoleEncoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
+	consoleEncoderConfig.EncodeCaller = func(ec zapcore.EntryCaller, pae zapcore.PrimitiveArrayEncoder) {
+		// custom encoding of the caller, now is set to the trimmed file path
+		pae.AppendString(ec.TrimmedPath())
+	}
+	return zapcore.NewConsoleEncoder(consoleEncoderConfig)
+}
+
+// IsColorTerminal reports whether output is a terminal which should be
+// colored, following the NO_COLOR and TERM environment variables.
+func IsColorTerminal(output io.Writer) bool {
+	if _, noColor := os.LookupEnv("NO_COLOR"); noColor || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	file, ok := output.(*os.File)
+	return ok && (isatty.IsTerminal(file.Fd()) || isatty.IsCygwinTerminal(file.Fd()))
+}
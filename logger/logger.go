@@ -1,11 +1,20 @@
+// Package logger is the logger used before the app logger of loggerfx is
+// constructed, e.g. while loading the config. The lines are printed in the
+// console format of loggerfx and buffered, to be replayed into the sinks of
+// loggerfx once the app logger is constructed.
 package logger
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"runtime"
+	"sync"
 	"time"
 
 	"github.com/fatih/color"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 type LogLevel string
@@ -28,6 +37,13 @@ var (
 	FatalColor LogColor = color.New(color.FgRed, color.Bold)
 )
 
+// Output is where the log lines are written to.
+var Output io.Writer = os.Stdout
+
+// Level is the minimum level of the lines printed to Output, lines of every
+// level are replayed into the app logger.
+var Level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
 var LogLevelColor = map[LogLevel]LogColor{
 	DebugLevel: DebugColor,
 	InfoLevel:  InfoColor,
@@ -36,37 +52,150 @@ var LogLevelColor = map[LogLevel]LogColor{
 	FatalLevel: FatalColor,
 }
 
+var zapLevels = map[LogLevel]zapcore.Level{
+	DebugLevel: zapcore.DebugLevel,
+	InfoLevel:  zapcore.InfoLevel,
+	WarnLevel:  zapcore.WarnLevel,
+	ErrorLevel: zapcore.ErrorLevel,
+	FatalLevel: zapcore.FatalLevel,
+}
+
+// bufferSize is the maximum number of lines kept until the app logger is
+// constructed.
+const bufferSize = 1000
+
+type bootstrap struct {
+	mu      sync.Mutex
+	console zapcore.Core
+	buffer  []zapcore.Entry
+	dropped int
+	// attached is the core of the app logger, written to instead of the
+	// console when set
+	attached   zapcore.Core
+	generation int
+}
+
+var std = &bootstrap{
+	console: zapcore.NewCore(
+		NewConsoleEncoder(IsColorTerminal(Output)),
+		zapcore.AddSync(writerFunc(func(p []byte) (int, error) { return Output.Write(p) })),
+		&Level,
+	),
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// write logs the message with the caller of the exported function.
+func (b *bootstrap) write(level zapcore.Level, message string) error {
+	entry := zapcore.Entry{
+		Level:   level,
+		Time:    time.Now(),
+		Message: message,
+		Caller:  zapcore.NewEntryCaller(runtime.Caller(2)),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.attached != nil {
+		if checked := b.attached.Check(entry, nil); checked != nil {
+			checked.Write()
+		}
+		return nil
+	}
+	if len(b.buffer) < bufferSize {
+		b.buffer = append(b.buffer, entry)
+	} else {
+		b.dropped++
+	}
+	if !b.console.Enabled(level) {
+		return nil
+	}
+	return b.console.Write(entry, nil)
+}
+
+// Attach replays the buffered lines into replay, and writes the later lines
+// to core instead of Output until the returned detach function is called.
+// replay should be core without its console output, as the buffered lines
+// were already printed.
+func Attach(replay, core zapcore.Core) (detach func()) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	for _, entry := range std.buffer {
+		if checked := replay.Check(entry, nil); checked != nil {
+			checked.Write()
+		}
+	}
+	if std.dropped > 0 {
+		entry := zapcore.Entry{
+			Level:   zapcore.WarnLevel,
+			Time:    time.Now(),
+			Message: fmt.Sprintf("%d bootstrap log lines dropped", std.dropped),
+		}
+		if checked := replay.Check(entry, nil); checked != nil {
+			checked.Write()
+		}
+	}
+	std.buffer = nil
+	std.dropped = 0
+	std.attached = core
+	std.generation++
+	generation := std.generation
+	return func() {
+		std.mu.Lock()
+		defer std.mu.Unlock()
+		// a later attached logger is kept
+		if std.generation == generation {
+			std.attached = nil
+		}
+	}
+}
+
 func Log(logLevel LogLevel, message string) error {
-	_, err := fmt.Printf("%s\t%s\t%s\n",
-		time.Now().Format(time.RFC3339),
-		(*color.Color)(LogLevelColor[logLevel]).Sprintf("[%s]", logLevel),
-		message,
-	)
-	return err
+	return std.write(zapLevels[logLevel], message)
+}
+
+func Debug(message string) error {
+	return std.write(zapcore.DebugLevel, message)
+}
+
+func Debugf(format string, a ...any) error {
+	return std.write(zapcore.DebugLevel, fmt.Sprintf(format, a...))
 }
 
 func Info(message string) error {
-	return Log(InfoLevel, message)
+	return std.write(zapcore.InfoLevel, message)
 }
 
 func Infof(format string, a ...any) error {
-	return Info(fmt.Sprintf(format, a...))
+	return std.write(zapcore.InfoLevel, fmt.Sprintf(format, a...))
 }
 
 func Warn(message string) error {
-	return Log(WarnLevel, message)
+	return std.write(zapcore.WarnLevel, message)
 }
 
 func Warnf(format string, a ...any) error {
-	return Warn(fmt.Sprintf(format, a...))
+	return std.write(zapcore.WarnLevel, fmt.Sprintf(format, a...))
+}
+
+func Error(message string) error {
+	return std.write(zapcore.ErrorLevel, message)
+}
+
+func Errorf(format string, a ...any) error {
+	return std.write(zapcore.ErrorLevel, fmt.Sprintf(format, a...))
 }
 
 func Fatal(message string) {
-	Log(FatalLevel, message)
+	std.write(zapcore.FatalLevel, message)
 	os.Exit(1)
 }
 
 func Fatalf(format string, a ...any) {
-	Fatal(fmt.Sprintf(format, a...))
+	std.write(zapcore.FatalLevel, fmt.Sprintf(format, a...))
 	os.Exit(1)
 }
@@ -0,0 +1,71 @@
+package loggerfx
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/astaclinic/astafx/logger"
+)
+
+// ContextLogger returns the logger of the request of a context, or the
+// logger of its app, so the apps of a process keep logging to their own
+// sinks.
+type ContextLogger struct {
+	logger *zap.SugaredLogger
+}
+
+func NewContextLogger(logger *zap.SugaredLogger) *ContextLogger {
+	return &ContextLogger{logger}
+}
+
+// FromContext returns the logger of the request of ctx, with the request ID,
+// route and peer fields added by the routerfx and grpcfx middleware, or the
+// logger of the app if ctx is not of a request.
+func (c *ContextLogger) FromContext(ctx context.Context) *zap.SugaredLogger {
+	return logger.FromContext(ctx, c.logger)
+}
+
+// running holds the loggers of the running apps, the latest started last.
+var running struct {
+	mu      sync.Mutex
+	loggers []*zap.SugaredLogger
+}
+
+// addRunning adds the logger of an app until the returned function is
+// called when the app stops.
+func addRunning(l *zap.SugaredLogger) (remove func()) {
+	running.mu.Lock()
+	defer running.mu.Unlock()
+	running.loggers = append(running.loggers, l)
+	return func() {
+		running.mu.Lock()
+		defer running.mu.Unlock()
+		for i, other := range running.loggers {
+			if other == l {
+				running.loggers = append(running.loggers[:i], running.loggers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// FromContext returns the logger of the request of ctx like
+// ContextLogger.FromContext, for code without access to the ContextLogger of
+// its app. It falls back to the logger of the latest started app which is
+// still running, or to the global zap logger if no app is running.
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	running.mu.Lock()
+	fallback := zap.S()
+	if n := len(running.loggers); n > 0 {
+		fallback = running.loggers[n-1]
+	}
+	running.mu.Unlock()
+	return logger.FromContext(ctx, fallback)
+}
+
+// NewContext returns a copy of ctx carrying logger, returned by FromContext.
+func NewContext(ctx context.Context, l *zap.SugaredLogger) context.Context {
+	return logger.NewContext(ctx, l)
+}
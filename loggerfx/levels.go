@@ -0,0 +1,190 @@
+package loggerfx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/astaclinic/astafx/configfx"
+	"github.com/astaclinic/astafx/routerfx"
+)
+
+// Levels holds the levels of the log outputs, which can be changed at
+// runtime through the config, the level handler route or the debug signal.
+type Levels struct {
+	mu         sync.Mutex
+	levels     map[string]zap.AtomicLevel
+	configured map[string]zapcore.Level
+	// revert is set while the levels are temporarily lowered to debug
+	revert *time.Timer
+}
+
+func newLevels(configured map[string]zapcore.Level) *Levels {
+	l := &Levels{
+		levels:     map[string]zap.AtomicLevel{},
+		configured: configured,
+	}
+	for name, level := range configured {
+		l.levels[name] = zap.NewAtomicLevelAt(level)
+	}
+	return l
+}
+
+// Level returns the atomic level of the named output.
+func (l *Levels) Level(name string) zap.AtomicLevel {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.levels[name]
+}
+
+// Get returns the current level of every output.
+func (l *Levels) Get() map[string]LogLevel {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	levels := map[string]LogLevel{}
+	for name, level := range l.levels {
+		levels[name] = LogLevel(level.Level().String())
+	}
+	return levels
+}
+
+// Set changes the level of the named output until the next config change.
+func (l *Levels) Set(name string, level LogLevel) error {
+	zapLevel, ok := logLevelMap[level]
+	if !ok {
+		return fmt.Errorf("invalid log level %s", level)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	atomicLevel, ok := l.levels[name]
+	if !ok {
+		return fmt.Errorf("unknown log output %s", name)
+	}
+	atomicLevel.SetLevel(zapLevel)
+	return nil
+}
+
+// configure sets the levels given by the config, ending a temporary debug
+// level.
+func (l *Levels) configure(configured map[string]zapcore.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.configured = configured
+	l.stopDebug()
+}
+
+// ToggleDebug lowers every level to debug and reverts them to the configured
+// levels after d. If the levels are lowered already, they are reverted
+// immediately. It reports whether the levels are lowered.
+func (l *Levels) ToggleDebug(d time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.revert != nil {
+		l.stopDebug()
+		return false
+	}
+	for _, level := range l.levels {
+		level.SetLevel(zapcore.DebugLevel)
+	}
+	var revert *time.Timer
+	revert = time.AfterFunc(d, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		// a timer which fired while the levels were toggled again must not
+		// end the new debug period
+		if l.revert == revert {
+			l.stopDebug()
+		}
+	})
+	l.revert = revert
+	return true
+}
+
+// stopDebug reverts the levels to the configured ones, l.mu must be held.
+func (l *Levels) stopDebug() {
+	if l.revert != nil {
+		l.revert.Stop()
+		l.revert = nil
+	}
+	for name, level := range l.configured {
+		if atomicLevel, ok := l.levels[name]; ok {
+			atomicLevel.SetLevel(level)
+		}
+	}
+}
+
+// LevelHandler reports and changes the log levels over HTTP. GET returns the
+// level of every output as a JSON object, PUT takes an object with the
+// levels to change, e.g. {"console": "debug"}.
+//
+// PUT requires the configured level token as a bearer token, and is
+// disabled if no token is configured.
+type LevelHandler struct {
+	levels *Levels
+	config *configfx.Reloadable[LoggerConfig]
+}
+
+func NewLevelHandler(levels *Levels, config *configfx.Reloadable[LoggerConfig]) *LevelHandler {
+	return &LevelHandler{levels, config}
+}
+
+// authorize reports whether r carries the level token, writing the error
+// response if not.
+func (lh *LevelHandler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	token := lh.config.Load().LevelToken
+	if token == "" {
+		http.Error(w, "changing the log levels is disabled", http.StatusForbidden)
+		return false
+	}
+	return routerfx.AuthorizeBearer(w, r, token)
+}
+
+func (lh *LevelHandler) HttpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			if !lh.authorize(w, r) {
+				return
+			}
+			var levels map[string]LogLevel
+			if err := json.NewDecoder(r.Body).Decode(&levels); err != nil {
+				http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+				return
+			}
+			// check every level before changing any of them
+			current := lh.levels.Get()
+			for name, level := range levels {
+				if _, ok := current[name]; !ok {
+					http.Error(w, fmt.Sprintf("unknown log output %s", name), http.StatusBadRequest)
+					return
+				}
+				if _, ok := logLevelMap[level]; !ok {
+					http.Error(w, fmt.Sprintf("invalid log level %s", level), http.StatusBadRequest)
+					return
+				}
+			}
+			for name, level := range levels {
+				if err := lh.levels.Set(name, level); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lh.levels.Get())
+	})
+}
+
+func (lh *LevelHandler) RoutePattern() string {
+	return "/logs/level"
+}
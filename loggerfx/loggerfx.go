@@ -1,38 +1,38 @@
 package loggerfx
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path"
+	"time"
 
-	"github.com/fatih/color"
 	"github.com/go-playground/validator/v10"
-	"github.com/spf13/viper"
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxevent"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"gopkg.in/natefinch/lumberjack.v2"
 
 	"github.com/astaclinic/astafx/config"
+	"github.com/astaclinic/astafx/configfx"
 	"github.com/astaclinic/astafx/logger"
+	"github.com/astaclinic/astafx/routerfx"
 )
 
 var Module = fx.Options(
+	configfx.Provide("logs", defaultConfig()),
+	configfx.ProvideValidation("loglevel", validateLogLevel),
+	configfx.ProvideValidation("regexp", validateRegexp),
 	fx.Provide(New),
+	fx.Provide(NewContextLogger),
+	fx.Provide(routerfx.AsHandlerRoute(NewLevelHandler)),
+	fx.Invoke(HandleDebugSignal),
 	fx.WithLogger(func(logger *zap.SugaredLogger) fxevent.Logger {
 		return &fxevent.ZapLogger{Logger: logger.Desugar()}
 	}),
-	fx.Decorate(RegisterLogLevelValidation),
 )
 
-func RegisterLogLevelValidation(validate *validator.Validate) (*validator.Validate, error) {
-	if err := validate.RegisterValidation("loglevel", validateLogLevel); err != nil {
-		return nil, err
-	}
-	return validate, nil
-}
-
 type LogLevel string
 
 var (
@@ -67,62 +67,201 @@ type LoggerConfig struct {
 		File    LogLevel `mapstructure:"file" yaml:"file" validate:"required,loglevel"`
 		Console LogLevel `mapstructure:"console" yaml:"console" validate:"required,loglevel"`
 	} `mapstructure:"level" yaml:"level" validate:"required"`
+	File    FileConfig    `mapstructure:"file" yaml:"file" validate:"required"`
+	Console ConsoleConfig `mapstructure:"console" yaml:"console" validate:"required"`
+	// Sampling is set by the name of the sink, sinks without a config are not sampled
+	Sampling  map[string]SamplingConfig `mapstructure:"sampling" yaml:"sampling" validate:"dive"`
+	RateLimit RateLimitConfig           `mapstructure:"rate_limit" yaml:"rate_limit" validate:"required"`
+	Redact    RedactConfig              `mapstructure:"redact" yaml:"redact"`
+	// DebugDuration is how long the levels are lowered to debug by SIGUSR1
+	DebugDuration time.Duration `mapstructure:"debug_duration" yaml:"debug_duration" validate:"required"`
+	// LevelToken is the bearer token required to change the levels over
+	// HTTP, which cannot be changed if it is empty
+	LevelToken string `mapstructure:"level_token" yaml:"level_token" secret:"true"`
 }
 
-func init() {
-	// config must have a default value for viper to load config from env variables
-	// default value of empty string (zero value) will not pass the "required" config validation
-	viper.SetDefault("logs.path", path.Join("/var/log", config.GetPackageName()))
-	viper.SetDefault("logs.level.file", InfoLevel)
-	viper.SetDefault("logs.level.console", InfoLevel)
+func defaultConfig() LoggerConfig {
+	defaults := LoggerConfig{
+		Path: path.Join("/var/log", config.GetPackageName()),
+	}
+	defaults.Level.File = InfoLevel
+	defaults.Level.Console = InfoLevel
+	defaults.File = FileConfig{
+		Enabled: true,
+		Name:    "server.log",
+		MaxSize: 100,
+		Rotate:  RotateSize,
+	}
+	defaults.Console = ConsoleConfig{
+		Encoding: ConsoleEncoding,
+		Color:    ColorAuto,
+		Output:   StdoutOutput,
+	}
+	defaults.RateLimit = RateLimitConfig{
+		Enabled:  false,
+		Burst:    100,
+		Interval: time.Minute,
+	}
+	defaults.Redact = DefaultRedactConfig()
+	defaults.DebugDuration = 10 * time.Minute
+	return defaults
 }
 
-func New(config *LoggerConfig) (*zap.SugaredLogger, error) {
-	// create directory if needed
-	err := os.MkdirAll(config.Path, os.ModePerm)
+type Params struct {
+	fx.In
+	Lifecycle fx.Lifecycle
+	Config    *configfx.Reloadable[LoggerConfig]
+	Sinks     []LogSink `group:"logSinks"`
+}
+
+type Result struct {
+	fx.Out
+	Logger   *zap.SugaredLogger
+	Levels   *Levels
+	Redactor *Redactor
+}
+
+func New(p Params) (Result, error) {
+	config := p.Config.Load()
+
+	sinks := []LogSink{&consoleSink{config}}
+	if config.File.Enabled {
+		file, err := newFileSink(p.Lifecycle, config)
+		if err != nil {
+			return Result{}, err
+		}
+		sinks = append(sinks, file)
+	}
+	sinks = append(sinks, p.Sinks...)
+
+	// setting the log level for every sink
+	// the levels follow config reloads, changes to other settings require a restart
+	configured := map[string]zapcore.Level{}
+	for _, sink := range sinks {
+		if _, ok := configured[sink.Name()]; ok {
+			return Result{}, fmt.Errorf("duplicate log sink %s", sink.Name())
+		}
+		configured[sink.Name()] = sink.Level()
+	}
+	levels := newLevels(configured)
+	p.Config.Subscribe(func(_, new *LoggerConfig) {
+		levels.configure(configuredLevels(new, p.Sinks))
+	})
+
+	redactor, err := NewRedactor(config.Redact)
 	if err != nil {
-		return nil, fmt.Errorf("error in creating log file folder for writing: %w", err)
+		return Result{}, err
+	}
+	cores := make([]zapcore.Core, 0, len(sinks))
+	// the bootstrap log lines were printed to the console already
+	replayCores := make([]zapcore.Core, 0, len(sinks))
+	for _, sink := range sinks {
+		core, err := sink.Core(levels.Level(sink.Name()))
+		if err != nil {
+			return Result{}, fmt.Errorf("error in creating log sink %s: %w", sink.Name(), err)
+		}
+		core = redactor.Wrap(core)
+		if sampling, ok := config.Sampling[sink.Name()]; ok {
+			core = newSampler(core, sampling)
+		}
+		cores = append(cores, core)
+		if sink.Name() != consoleOutput {
+			replayCores = append(replayCores, core)
+		}
+	}
+	for name := range config.Sampling {
+		if _, ok := configured[name]; !ok {
+			return Result{}, fmt.Errorf("sampling configured for unknown log sink %s", name)
+		}
 	}
 
-	// create a new writer for log rotation
-	fileWriter := zapcore.AddSync(&lumberjack.Logger{
-		Filename: path.Join(config.Path, "server.log"),
+	core := zapcore.NewTee(cores...)
+	if config.RateLimit.Enabled {
+		core = &rateLimitCore{
+			Core:    core,
+			limiter: newRateLimiter(p.Lifecycle, core, config.RateLimit),
+		}
+	}
+
+	// the lines of the bootstrap logger go to the sinks until the app stops
+	detach := logger.Attach(zapcore.NewTee(replayCores...), core)
+	zapLogger := zap.New(core, zap.AddCaller())
+	// the logger is the fallback of FromContext until the app stops, for
+	// code without a request scoped logger
+	removeRunning := addRunning(zapLogger.Sugar())
+	p.Lifecycle.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			removeRunning()
+			detach()
+			return nil
+		},
 	})
 
-	// setting the log level for file/console log output
-	fileLogLevel := logLevelMap[config.Level.File]
-	consoleLogLevel := logLevelMap[config.Level.Console]
-
-	// setup the encoders
-	fileEncoderConfig := zap.NewProductionEncoderConfig()
-	fileEncoder := zapcore.NewJSONEncoder(fileEncoderConfig)
-	consoleEncoderConfig := zap.NewProductionEncoderConfig()
-	colorMap := map[zapcore.Level]*color.Color{
-		zapcore.DebugLevel:  logger.DebugColor,
-		zapcore.InfoLevel:   logger.InfoColor,
-		zapcore.WarnLevel:   logger.WarnColor,
-		zapcore.ErrorLevel:  logger.ErrorColor,
-		zapcore.DPanicLevel: logger.FatalColor,
-		zapcore.FatalLevel:  logger.FatalColor,
-		zapcore.PanicLevel:  logger.FatalColor,
+	return Result{
+		Logger:   zapLogger.Sugar(),
+		Levels:   levels,
+		Redactor: redactor,
+	}, nil
+}
+
+// names of the built-in log sinks
+const (
+	fileOutput    = "file"
+	consoleOutput = "console"
+)
+
+// configuredLevels returns the levels of the built-in sinks given by config
+// and the initial levels of the contributed sinks.
+func configuredLevels(config *LoggerConfig, sinks []LogSink) map[string]zapcore.Level {
+	levels := map[string]zapcore.Level{
+		consoleOutput: logLevelMap[config.Level.Console],
 	}
-	consoleEncoderConfig.EncodeLevel = func(l zapcore.Level, pae zapcore.PrimitiveArrayEncoder) {
-		// custom encoding of level string as [INFO] style
-		pae.AppendString(colorMap[l].Sprintf("[%s]", l.CapitalString()))
+	if config.File.Enabled {
+		levels[fileOutput] = logLevelMap[config.Level.File]
 	}
-	consoleEncoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
-	consoleEncoderConfig.EncodeCaller = func(ec zapcore.EntryCaller, pae zapcore.PrimitiveArrayEncoder) {
-		// custom encoding of the caller, now is set to the trimmed file path
-		pae.AppendString(ec.TrimmedPath())
+	for _, sink := range sinks {
+		levels[sink.Name()] = sink.Level()
 	}
-	consoleEncoder := zapcore.NewConsoleEncoder(consoleEncoderConfig)
+	return levels
+}
 
-	// create the two cores for the logger
-	// when writing to a file, the *os.File need to be locked with Lock() for concurrent access
-	core := zapcore.NewTee(
-		zapcore.NewCore(fileEncoder, fileWriter, fileLogLevel),
-		zapcore.NewCore(consoleEncoder, zapcore.Lock(os.Stdout), consoleLogLevel),
-	)
+type DebugSignalParams struct {
+	fx.In
+	Lifecycle fx.Lifecycle
+	Config    *configfx.Reloadable[LoggerConfig]
+	Levels    *Levels
+	Logger    *zap.SugaredLogger
+}
 
-	return zap.New(core, zap.AddCaller()).Sugar(), nil
+// HandleDebugSignal lowers the log levels to debug on SIGUSR1, until the
+// configured duration passes or the signal is received again.
+func HandleDebugSignal(p DebugSignalParams) {
+	if debugSignal == nil {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			signal.Notify(signals, debugSignal)
+			go func() {
+				defer close(done)
+				for range signals {
+					duration := p.Config.Load().DebugDuration
+					if p.Levels.ToggleDebug(duration) {
+						p.Logger.Infow("lowered log levels to debug", "duration", duration)
+					} else {
+						p.Logger.Infow("reverted log levels", "levels", p.Levels.Get())
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			signal.Stop(signals)
+			close(signals)
+			<-done
+			return nil
+		},
+	})
 }
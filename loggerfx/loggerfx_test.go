@@ -0,0 +1,264 @@
+package loggerfx_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/astaclinic/astafx/config"
+	"github.com/astaclinic/astafx/configfx"
+	bootstrap "github.com/astaclinic/astafx/logger"
+	"github.com/astaclinic/astafx/loggerfx"
+)
+
+func newLogger(t *testing.T, opts ...fx.Option) (*zap.SugaredLogger, *loggerfx.Levels) {
+	t.Helper()
+	t.Setenv("ASTA_LOGS_PATH", t.TempDir())
+	var logger *zap.SugaredLogger
+	var levels *loggerfx.Levels
+	app := fx.New(
+		fx.NopLogger,
+		fx.Supply(config.Options{EnvPrefix: "asta"}),
+		configfx.Module,
+		loggerfx.Module,
+		fx.Options(opts...),
+		fx.Populate(&logger, &levels),
+	)
+	if err := app.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return logger, levels
+}
+
+func TestLevels(t *testing.T) {
+	t.Run("Test level handler", func(t *testing.T) {
+		t.Setenv("ASTA_LOGS_LEVEL_TOKEN", "s3cr3t")
+		var config *configfx.Reloadable[loggerfx.LoggerConfig]
+		_, levels := newLogger(t, fx.Populate(&config))
+		handler := loggerfx.NewLevelHandler(levels, config).HttpHandler()
+
+		for _, authorization := range []string{"", "Bearer wrong", "s3cr3t"} {
+			req := httptest.NewRequest(http.MethodPut, "/logs/level", strings.NewReader(`{"console": "debug"}`))
+			req.Header.Set("Authorization", authorization)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("unexpected status for authorization %q, got %d, expected %d", authorization, rec.Code, http.StatusUnauthorized)
+			}
+		}
+
+		req := httptest.NewRequest(http.MethodPut, "/logs/level", strings.NewReader(`{"console": "debug"}`))
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		expected := `{"console":"debug","file":"info"}`
+		if got := strings.TrimSpace(rec.Body.String()); rec.Code != http.StatusOK || got != expected {
+			t.Errorf("unexpected response, got %d %s, expected %s", rec.Code, got, expected)
+		}
+
+		req = httptest.NewRequest(http.MethodPut, "/logs/level", strings.NewReader(`{"file": "verbose"}`))
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("unexpected status for invalid level, got %d, expected %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+	t.Run("Test level handler without token", func(t *testing.T) {
+		var config *configfx.Reloadable[loggerfx.LoggerConfig]
+		_, levels := newLogger(t, fx.Populate(&config))
+		handler := loggerfx.NewLevelHandler(levels, config).HttpHandler()
+
+		req := httptest.NewRequest(http.MethodPut, "/logs/level", strings.NewReader(`{"console": "debug"}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("unexpected status, got %d, expected %d", rec.Code, http.StatusForbidden)
+		}
+		if got := levels.Get()["console"]; got != loggerfx.InfoLevel {
+			t.Errorf("level changed without token, got %s", got)
+		}
+	})
+	t.Run("Test debug toggle", func(t *testing.T) {
+		_, levels := newLogger(t)
+		if !levels.ToggleDebug(time.Hour) {
+			t.Fatalf("expected levels to be lowered")
+		}
+		if got := levels.Get()["file"]; got != loggerfx.DebugLevel {
+			t.Errorf("unexpected level, got %s, expected %s", got, loggerfx.DebugLevel)
+		}
+		if levels.ToggleDebug(time.Hour) {
+			t.Fatalf("expected levels to be reverted")
+		}
+		if got := levels.Get()["file"]; got != loggerfx.InfoLevel {
+			t.Errorf("unexpected level, got %s, expected %s", got, loggerfx.InfoLevel)
+		}
+
+		levels.ToggleDebug(10 * time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		if got := levels.Get()["console"]; got != loggerfx.InfoLevel {
+			t.Errorf("levels not reverted after duration, got %s", got)
+		}
+
+		// the timer of a previous toggle must not revert a later one
+		levels.ToggleDebug(20 * time.Millisecond)
+		levels.ToggleDebug(time.Hour)
+		levels.ToggleDebug(time.Hour)
+		time.Sleep(50 * time.Millisecond)
+		if got := levels.Get()["console"]; got != loggerfx.DebugLevel {
+			t.Errorf("levels reverted by a previous toggle, got %s", got)
+		}
+	})
+}
+
+func TestLogSinks(t *testing.T) {
+	sink := loggerfx.NewRingBufferSink("memory", zapcore.WarnLevel, 2)
+	logger, levels := newLogger(t,
+		fx.Provide(loggerfx.AsLogSink(func() *loggerfx.RingBufferSink { return sink })),
+	)
+	logger.Info("below the level of the sink")
+	logger.Warn("first")
+	logger.Warn("second")
+	logger.Warn("third")
+
+	lines := sink.Lines()
+	if len(lines) != 2 || !strings.Contains(lines[0], `"msg":"second"`) || !strings.Contains(lines[1], `"msg":"third"`) {
+		t.Errorf("unexpected buffered lines, got %v", lines)
+	}
+	if got := levels.Get()["memory"]; got != loggerfx.WarnLevel {
+		t.Errorf("unexpected level of sink, got %s, expected %s", got, loggerfx.WarnLevel)
+	}
+}
+
+func TestFileDisabled(t *testing.T) {
+	t.Setenv("ASTA_LOGS_FILE_ENABLED", "false")
+	t.Setenv("ASTA_LOGS_CONSOLE_ENCODING", "json")
+	_, levels := newLogger(t)
+	if _, ok := levels.Get()["file"]; ok {
+		t.Errorf("unexpected file sink when disabled, got %v", levels.Get())
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Setenv("ASTA_LOGS_PATH", t.TempDir())
+	t.Setenv("ASTA_LOGS_RATE_LIMIT_ENABLED", "true")
+	t.Setenv("ASTA_LOGS_RATE_LIMIT_BURST", "2")
+	sink := loggerfx.NewRingBufferSink("memory", zapcore.WarnLevel, 10)
+	var logger *zap.SugaredLogger
+	app := fx.New(
+		fx.NopLogger,
+		fx.Supply(config.Options{EnvPrefix: "asta"}),
+		configfx.Module,
+		loggerfx.Module,
+		fx.Provide(loggerfx.AsLogSink(func() *loggerfx.RingBufferSink { return sink })),
+		fx.Populate(&logger),
+	)
+	ctx := context.Background()
+	if err := app.Start(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		logger.Warn("repeated")
+	}
+	logger.Warn("other")
+	// the summary of the suppressed entries is logged when the app stops
+	if err := app.Stop(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := sink.Lines()
+	if len(lines) != 4 || !strings.Contains(lines[2], `"msg":"other"`) || !strings.Contains(lines[3], `"msg":"3 messages suppressed"`) {
+		t.Errorf("unexpected rate limited lines, got %v", lines)
+	}
+}
+
+func TestBootstrapReplay(t *testing.T) {
+	bootstrap.Warn("before the app logger")
+	sink := loggerfx.NewRingBufferSink("memory", zapcore.WarnLevel, 10)
+	newLogger(t, fx.Provide(loggerfx.AsLogSink(func() *loggerfx.RingBufferSink { return sink })))
+	bootstrap.Warn("after the app logger")
+
+	var replayed, attached bool
+	for _, line := range sink.Lines() {
+		replayed = replayed || strings.Contains(line, `"msg":"before the app logger"`)
+		attached = attached || strings.Contains(line, `"msg":"after the app logger"`)
+	}
+	if !replayed || !attached {
+		t.Errorf("bootstrap lines not written to the sink, got %v", sink.Lines())
+	}
+}
+
+// newStartedApp returns the context logger of a started app logging to a
+// sink at the warn level, as the events of fx are logged at the info level.
+func newStartedApp(t *testing.T) (*fx.App, *loggerfx.ContextLogger, *loggerfx.RingBufferSink) {
+	t.Helper()
+	sink := loggerfx.NewRingBufferSink("memory", zapcore.WarnLevel, 10)
+	var contextLogger *loggerfx.ContextLogger
+	app := fx.New(
+		fx.NopLogger,
+		fx.Supply(config.Options{EnvPrefix: "asta"}),
+		configfx.Module,
+		loggerfx.Module,
+		fx.Provide(loggerfx.AsLogSink(func() *loggerfx.RingBufferSink { return sink })),
+		fx.Populate(&contextLogger),
+	)
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return app, contextLogger, sink
+}
+
+// lastLine returns the last line of the sink, the bootstrap log lines are
+// replayed into the sink before.
+func lastLine(sink *loggerfx.RingBufferSink) string {
+	lines := sink.Lines()
+	if len(lines) == 0 {
+		return ""
+	}
+	return lines[len(lines)-1]
+}
+
+func TestFromContext(t *testing.T) {
+	t.Setenv("ASTA_LOGS_PATH", t.TempDir())
+	ctx := context.Background()
+	appA, loggerA, sinkA := newStartedApp(t)
+	appB, loggerB, sinkB := newStartedApp(t)
+
+	loggerA.FromContext(ctx).Error("without request")
+	if !strings.Contains(lastLine(sinkA), `"msg":"without request"`) {
+		t.Errorf("unexpected line of app, got %s", lastLine(sinkA))
+	}
+	loggerB.FromContext(loggerfx.NewContext(ctx, loggerA.FromContext(ctx).With("request_id", "abc"))).Warn("with request")
+	if !strings.Contains(lastLine(sinkA), `"request_id":"abc"`) {
+		t.Errorf("unexpected line of request, got %s", lastLine(sinkA))
+	}
+
+	// the latest started app is the fallback until it stops
+	loggerfx.FromContext(ctx).Error("latest app")
+	if !strings.Contains(lastLine(sinkB), `"msg":"latest app"`) {
+		t.Errorf("unexpected line of latest app, got %s", lastLine(sinkB))
+	}
+	if err := appA.Stop(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loggerfx.FromContext(ctx).Error("after stop of other app")
+	if !strings.Contains(lastLine(sinkB), `"msg":"after stop of other app"`) {
+		t.Errorf("unexpected line after stop of other app, got %s", lastLine(sinkB))
+	}
+	if err := appB.Stop(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loggerfx.FromContext(ctx).Error("after stop")
+	for _, sink := range []*loggerfx.RingBufferSink{sinkA, sinkB} {
+		if strings.Contains(lastLine(sink), `"msg":"after stop"`) {
+			t.Errorf("unexpected line of stopped app, got %s", lastLine(sink))
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// Package loggerfxtest provides helpers to test the logging of code using the
+// loggers of loggerfx.
+package loggerfxtest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/astaclinic/astafx/loggerfx"
+)
+
+// AssertRedacted calls log with a logger redacting like loggerfx by config,
+// and fails the test if any of values is written to the output. The output is
+// returned for further assertions.
+func AssertRedacted(t testing.TB, config loggerfx.RedactConfig, log func(logger *zap.Logger), values ...string) string {
+	t.Helper()
+	redactor, err := loggerfx.NewRedactor(config)
+	if err != nil {
+		t.Fatalf("invalid redact config: %v", err)
+	}
+	var buffer bytes.Buffer
+	encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	core := redactor.Wrap(zapcore.NewCore(encoder, zapcore.AddSync(&buffer), zapcore.DebugLevel))
+	log(zap.New(core))
+
+	output := buffer.String()
+	for _, value := range values {
+		if strings.Contains(output, value) {
+			t.Errorf("value %q not redacted in output:\n%s", value, output)
+		}
+	}
+	return output
+}
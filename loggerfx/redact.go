@@ -0,0 +1,255 @@
+package loggerfx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/astaclinic/astafx/config"
+)
+
+type RedactConfig struct {
+	// Fields are glob patterns of field names, matched case-insensitively,
+	// whose values are masked entirely. They also apply to the keys of logged
+	// objects and to the parameters of query fields.
+	Fields []string `mapstructure:"fields" yaml:"fields"`
+	// Patterns are regular expressions masked in the messages and every string
+	// value, e.g. emails or patient identifiers
+	Patterns []string `mapstructure:"patterns" yaml:"patterns" validate:"dive,regexp"`
+	// SQLFields are the names of fields holding SQL statements, whose string
+	// literals are masked
+	SQLFields []string `mapstructure:"sql_fields" yaml:"sql_fields"`
+	// QueryFields are the names of fields holding URL query strings, whose
+	// parameters matching Fields are masked
+	QueryFields []string `mapstructure:"query_fields" yaml:"query_fields"`
+}
+
+// DefaultRedactConfig returns the redaction of the logs.redact config by default.
+func DefaultRedactConfig() RedactConfig {
+	return RedactConfig{
+		Fields: []string{"*password*", "*secret*", "*token*", "authorization", "cookie", "set-cookie", "dsn", "params"},
+		Patterns: []string{
+			// emails
+			`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+			// bearer tokens of authorization headers
+			`(?i)bearer\s+[A-Za-z0-9\-._~+/]+=*`,
+		},
+		SQLFields:   []string{"sql"},
+		QueryFields: []string{"query"},
+	}
+}
+
+func validateRegexp(fieldLevel validator.FieldLevel) bool {
+	_, err := regexp.Compile(fieldLevel.Field().String())
+	return err == nil
+}
+
+var sqlLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+
+// Redactor masks the sensitive values of log entries before they are
+// encoded by the sinks.
+type Redactor struct {
+	fields      []string
+	patterns    []*regexp.Regexp
+	sqlFields   map[string]bool
+	queryFields map[string]bool
+}
+
+func NewRedactor(config RedactConfig) (*Redactor, error) {
+	r := &Redactor{
+		sqlFields:   map[string]bool{},
+		queryFields: map[string]bool{},
+	}
+	for _, field := range config.Fields {
+		field = strings.ToLower(field)
+		if _, err := path.Match(field, ""); err != nil {
+			return nil, fmt.Errorf("invalid redacted field pattern %q: %w", field, err)
+		}
+		r.fields = append(r.fields, field)
+	}
+	for _, pattern := range config.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redacted pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	for _, field := range config.SQLFields {
+		r.sqlFields[field] = true
+	}
+	for _, field := range config.QueryFields {
+		r.queryFields[field] = true
+	}
+	return r, nil
+}
+
+// Wrap returns a core which writes the redacted entries to core.
+func (r *Redactor) Wrap(core zapcore.Core) zapcore.Core {
+	return &redactCore{Core: core, redactor: r}
+}
+
+func (r *Redactor) sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, field := range r.fields {
+		if ok, _ := path.Match(field, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) redactString(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, config.Redacted)
+	}
+	return s
+}
+
+// RedactSQL masks the string literals and the patterns in a SQL statement,
+// or in text quoting statements such as the plans of EXPLAIN.
+func (r *Redactor) RedactSQL(sql string) string {
+	return r.redactString(sqlLiteral.ReplaceAllString(sql, "'"+config.Redacted+"'"))
+}
+
+func (r *Redactor) redactQuery(query string) string {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return r.redactString(query)
+	}
+	for key := range values {
+		if r.sensitive(key) {
+			values[key] = []string{config.Redacted}
+		}
+	}
+	return r.redactString(values.Encode())
+}
+
+func (r *Redactor) redactField(field zapcore.Field) zapcore.Field {
+	if r.sensitive(field.Key) {
+		return zap.String(field.Key, config.Redacted)
+	}
+	switch field.Type {
+	case zapcore.StringType:
+		switch {
+		case r.sqlFields[field.Key]:
+			field.String = sqlLiteral.ReplaceAllString(field.String, "'"+config.Redacted+"'")
+		case r.queryFields[field.Key]:
+			field.String = r.redactQuery(field.String)
+		}
+		field.String = r.redactString(field.String)
+	case zapcore.ByteStringType:
+		return zap.ByteString(field.Key, []byte(r.redactString(string(field.Interface.([]byte)))))
+	case zapcore.StringerType:
+		return r.redactField(zap.String(field.Key, stringOf(field)))
+	case zapcore.ErrorType:
+		return zap.String(field.Key, r.redactString(stringOf(field)))
+	case zapcore.ObjectMarshalerType, zapcore.ArrayMarshalerType, zapcore.InlineMarshalerType:
+		encoder := zapcore.NewMapObjectEncoder()
+		field.AddTo(encoder)
+		if field.Type == zapcore.InlineMarshalerType {
+			return zap.Inline(redactedObject(r.redactValue(encoder.Fields).(map[string]any)))
+		}
+		return zap.Any(field.Key, r.redactValue(encoder.Fields[field.Key]))
+	case zapcore.ReflectType:
+		// reflected values are encoded as JSON by the encoders, so the
+		// redaction works on their JSON form
+		data, err := json.Marshal(field.Interface)
+		if err != nil {
+			return field
+		}
+		var value any
+		if err := json.Unmarshal(data, &value); err != nil {
+			return field
+		}
+		return zap.Any(field.Key, r.redactValue(value))
+	}
+	return field
+}
+
+func (r *Redactor) redactValue(value any) any {
+	switch value := value.(type) {
+	case string:
+		return r.redactString(value)
+	case map[string]any:
+		redacted := make(map[string]any, len(value))
+		for key, v := range value {
+			if r.sensitive(key) {
+				redacted[key] = config.Redacted
+				continue
+			}
+			redacted[key] = r.redactValue(v)
+		}
+		return redacted
+	case []any:
+		redacted := make([]any, len(value))
+		for i, v := range value {
+			redacted[i] = r.redactValue(v)
+		}
+		return redacted
+	}
+	return value
+}
+
+func (r *Redactor) redactFields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		redacted[i] = r.redactField(field)
+	}
+	return redacted
+}
+
+// stringOf returns the string logged for a Stringer or error field.
+func stringOf(field zapcore.Field) (s string) {
+	defer func() {
+		// nil pointers with a value receiver panic like in the encoders
+		if err := recover(); err != nil {
+			s = fmt.Sprintf("PANIC=%v", err)
+		}
+	}()
+	switch value := field.Interface.(type) {
+	case fmt.Stringer:
+		return value.String()
+	case error:
+		return value.Error()
+	}
+	return fmt.Sprint(field.Interface)
+}
+
+// redactedObject is an inlined object after redaction.
+type redactedObject map[string]any
+
+func (o redactedObject) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
+	for key, value := range o {
+		zap.Any(key, value).AddTo(encoder)
+	}
+	return nil
+}
+
+// redactCore masks the sensitive values of the entries written to a sink.
+type redactCore struct {
+	zapcore.Core
+	redactor *Redactor
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(c.redactor.redactFields(fields)), redactor: c.redactor}
+}
+
+func (c *redactCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *redactCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = c.redactor.redactString(entry.Message)
+	return c.Core.Write(entry, c.redactor.redactFields(fields))
+}
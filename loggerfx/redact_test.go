@@ -0,0 +1,33 @@
+package loggerfx_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/astaclinic/astafx/loggerfx"
+	"github.com/astaclinic/astafx/loggerfx/loggerfxtest"
+)
+
+func TestRedact(t *testing.T) {
+	config := loggerfx.DefaultRedactConfig()
+	config.Patterns = append(config.Patterns, `P\d{6}`)
+
+	t.Run("Test fields", func(t *testing.T) {
+		output := loggerfxtest.AssertRedacted(t, config, func(logger *zap.Logger) {
+			logger.With(zap.String("access_token", "abc123")).Info("login of jane@example.com",
+				zap.String("Authorization", "Bearer xyz789"),
+				zap.String("query", "page=2&token=def456"),
+				zap.String("sql", "SELECT * FROM patients WHERE name = 'Jane Doe'"),
+				zap.Error(errors.New("no record of patient P123456")),
+				zap.Any("user", map[string]any{"name": "jane", "password": "hunter2"}),
+				zap.Any("params", []any{"Jane Roe", 42}),
+			)
+		}, "abc123", "jane@example.com", "xyz789", "def456", "Jane Doe", "P123456", "hunter2", "Jane Roe")
+		if !strings.Contains(output, "page=2") || !strings.Contains(output, `"name":"jane"`) {
+			t.Errorf("unexpected redaction of other values, got %s", output)
+		}
+	})
+}
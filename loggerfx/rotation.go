@@ -0,0 +1,141 @@
+package loggerfx
+
+import (
+	"os"
+	"os/signal"
+	"path"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+type RotateMode string
+
+var (
+	// RotateSize only rotates the log file when it reaches its max size
+	RotateSize RotateMode = "size"
+	// RotateHourly rotates the log file at the start of every hour
+	RotateHourly RotateMode = "hourly"
+	// RotateDaily rotates the log file at every midnight
+	RotateDaily RotateMode = "daily"
+)
+
+type FileConfig struct {
+	// Enabled writes the logs to a file in LoggerConfig.Path, it may be
+	// disabled on read-only container filesystems
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled"`
+	Name    string `mapstructure:"name" yaml:"name" validate:"required"`
+	// MaxSize is the size in megabytes at which the log file is rotated
+	MaxSize int `mapstructure:"max_size" yaml:"max_size" validate:"min=1"`
+	// MaxAge is the number of days to keep rotated log files, 0 keeps them forever
+	MaxAge int `mapstructure:"max_age" yaml:"max_age" validate:"min=0"`
+	// MaxBackups is the number of rotated log files to keep, 0 keeps all of them
+	MaxBackups int  `mapstructure:"max_backups" yaml:"max_backups" validate:"min=0"`
+	Compress   bool `mapstructure:"compress" yaml:"compress"`
+	// LocalTime uses the local time instead of UTC in the names of rotated
+	// log files and for the time based rotation
+	LocalTime bool       `mapstructure:"local_time" yaml:"local_time"`
+	Rotate    RotateMode `mapstructure:"rotate" yaml:"rotate" validate:"required,oneof=size hourly daily"`
+}
+
+// clock is the time source of the time based rotation.
+type clock interface {
+	Now() time.Time
+	// NewTimer returns a channel receiving the time after d and a function
+	// stopping the timer
+	NewTimer(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	timer := time.NewTimer(d)
+	return timer.C, timer.Stop
+}
+
+// rotatingFile writes to a log file rotated by size and optionally by time.
+// It is reopened on SIGHUP for compatibility with an external logrotate.
+type rotatingFile struct {
+	*lumberjack.Logger
+	rotate    RotateMode
+	localTime bool
+	clock     clock
+	signals   chan os.Signal
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newRotatingFile(dir string, config FileConfig) *rotatingFile {
+	return &rotatingFile{
+		Logger: &lumberjack.Logger{
+			Filename:   path.Join(dir, config.Name),
+			MaxSize:    config.MaxSize,
+			MaxAge:     config.MaxAge,
+			MaxBackups: config.MaxBackups,
+			Compress:   config.Compress,
+			LocalTime:  config.LocalTime,
+		},
+		rotate:    config.Rotate,
+		localTime: config.LocalTime,
+		clock:     systemClock{},
+		signals:   make(chan os.Signal, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+func (f *rotatingFile) start() {
+	if reopenSignal != nil {
+		signal.Notify(f.signals, reopenSignal)
+	}
+	go func() {
+		defer close(f.done)
+		for {
+			// a nil channel blocks forever when there is no time based rotation
+			var rotate <-chan time.Time
+			stopTimer := func() bool { return false }
+			if f.rotate == RotateHourly || f.rotate == RotateDaily {
+				now := f.clock.Now()
+				rotate, stopTimer = f.clock.NewTimer(f.nextRotation(now).Sub(now))
+			}
+			select {
+			case <-rotate:
+				f.Rotate()
+			case <-f.signals:
+				// lumberjack opens the file again on the next write, creating
+				// it if it has been moved away
+				f.Close()
+			case <-f.stop:
+			}
+			stopTimer()
+			select {
+			case <-f.stop:
+				return
+			default:
+			}
+		}
+	}()
+}
+
+func (f *rotatingFile) shutdown() error {
+	signal.Stop(f.signals)
+	close(f.stop)
+	<-f.done
+	return f.Close()
+}
+
+// nextRotation returns the start of the next hour or day after now.
+func (f *rotatingFile) nextRotation(now time.Time) time.Time {
+	if !f.localTime {
+		now = now.UTC()
+	}
+	year, month, day := now.Date()
+	if f.rotate == RotateHourly {
+		return time.Date(year, month, day, now.Hour()+1, 0, 0, 0, now.Location())
+	}
+	return time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+}
@@ -0,0 +1,183 @@
+package loggerfx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeClock fires the timers of the time based rotation on demand.
+type fakeClock struct {
+	now    time.Time
+	timers chan time.Duration
+	fire   chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{
+		now:    now,
+		timers: make(chan time.Duration, 1),
+		fire:   make(chan time.Time),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	c.timers <- d
+	return c.fire, func() bool { return true }
+}
+
+func writeLine(t *testing.T, f *rotatingFile, line string) {
+	t.Helper()
+	if _, err := f.Write([]byte(line + "\n")); err != nil {
+		t.Fatalf("failed to write log file: %v", err)
+	}
+}
+
+// waitFor polls cond until it holds, as lumberjack removes old files and
+// the signals are handled in the background.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func backups(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "server-*.log"))
+	if err != nil {
+		t.Fatalf("failed to list log files: %v", err)
+	}
+	return matches
+}
+
+func TestNextRotation(t *testing.T) {
+	zone := time.FixedZone("UTC+8", 8*60*60)
+	tests := []struct {
+		name      string
+		rotate    RotateMode
+		localTime bool
+		now       time.Time
+		expected  time.Time
+	}{
+		{"hourly", RotateHourly, false, time.Date(2023, 5, 5, 10, 59, 59, 999, time.UTC), time.Date(2023, 5, 5, 11, 0, 0, 0, time.UTC)},
+		{"hourly at the hour", RotateHourly, false, time.Date(2023, 5, 5, 11, 0, 0, 0, time.UTC), time.Date(2023, 5, 5, 12, 0, 0, 0, time.UTC)},
+		{"hourly at midnight", RotateHourly, false, time.Date(2023, 5, 5, 23, 30, 0, 0, time.UTC), time.Date(2023, 5, 6, 0, 0, 0, 0, time.UTC)},
+		{"daily", RotateDaily, false, time.Date(2023, 5, 5, 0, 0, 0, 0, time.UTC), time.Date(2023, 5, 6, 0, 0, 0, 0, time.UTC)},
+		{"daily at the end of the year", RotateDaily, false, time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"daily in UTC", RotateDaily, false, time.Date(2023, 5, 5, 7, 0, 0, 0, zone), time.Date(2023, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{"daily in local time", RotateDaily, true, time.Date(2023, 5, 5, 7, 0, 0, 0, zone), time.Date(2023, 5, 6, 0, 0, 0, 0, zone)},
+	}
+	for _, test := range tests {
+		t.Run("Test "+test.name, func(t *testing.T) {
+			f := &rotatingFile{rotate: test.rotate, localTime: test.localTime}
+			if got := f.nextRotation(test.now); !got.Equal(test.expected) {
+				t.Errorf("unexpected next rotation, got %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	t.Run("Test time based rotation", func(t *testing.T) {
+		dir := t.TempDir()
+		f := newRotatingFile(dir, FileConfig{Name: "server.log", MaxSize: 1, Rotate: RotateHourly})
+		now := time.Date(2023, 5, 5, 10, 45, 0, 0, time.UTC)
+		clock := newFakeClock(now)
+		f.clock = clock
+		writeLine(t, f, "before rotation")
+		f.start()
+		defer f.shutdown()
+
+		if d := <-clock.timers; d != 15*time.Minute {
+			t.Errorf("unexpected rotation timer, got %v, expected %v", d, 15*time.Minute)
+		}
+		clock.fire <- now.Add(15 * time.Minute)
+		// the next timer is requested after the rotation
+		<-clock.timers
+		if got := backups(t, dir); len(got) != 1 {
+			t.Fatalf("unexpected rotated files, got %v", got)
+		}
+		writeLine(t, f, "after rotation")
+		content, err := os.ReadFile(filepath.Join(dir, "server.log"))
+		if err != nil {
+			t.Fatalf("failed to read log file: %v", err)
+		}
+		if string(content) != "after rotation\n" {
+			t.Errorf("unexpected log file content, got %q", content)
+		}
+	})
+	t.Run("Test max backups", func(t *testing.T) {
+		dir := t.TempDir()
+		f := newRotatingFile(dir, FileConfig{Name: "server.log", MaxSize: 1, MaxBackups: 1, Rotate: RotateSize})
+		defer f.Close()
+		for i := 0; i < 3; i++ {
+			writeLine(t, f, "line")
+			if err := f.Rotate(); err != nil {
+				t.Fatalf("failed to rotate log file: %v", err)
+			}
+			// the names of rotated files have a millisecond resolution
+			time.Sleep(2 * time.Millisecond)
+		}
+		waitFor(t, "old backups to be removed", func() bool {
+			return len(backups(t, dir)) == 1
+		})
+	})
+	t.Run("Test max age", func(t *testing.T) {
+		dir := t.TempDir()
+		// lumberjack reads the time of rotation from the name of the file
+		old := filepath.Join(dir, "server-2000-01-01T00-00-00.000.log")
+		if err := os.WriteFile(old, []byte("old\n"), 0o644); err != nil {
+			t.Fatalf("failed to write old log file: %v", err)
+		}
+		f := newRotatingFile(dir, FileConfig{Name: "server.log", MaxSize: 1, MaxAge: 1, Rotate: RotateSize})
+		defer f.Close()
+		writeLine(t, f, "line")
+		if err := f.Rotate(); err != nil {
+			t.Fatalf("failed to rotate log file: %v", err)
+		}
+		waitFor(t, "expired backup to be removed", func() bool {
+			_, err := os.Stat(old)
+			return os.IsNotExist(err)
+		})
+		if got := backups(t, dir); len(got) != 1 {
+			t.Errorf("unexpected rotated files, got %v", got)
+		}
+	})
+	t.Run("Test reopen on signal", func(t *testing.T) {
+		dir := t.TempDir()
+		f := newRotatingFile(dir, FileConfig{Name: "server.log", MaxSize: 1, Rotate: RotateSize})
+		file := filepath.Join(dir, "server.log")
+		writeLine(t, f, "before move")
+		f.start()
+		defer f.shutdown()
+
+		// logrotate moves the file away before signalling
+		moved := filepath.Join(dir, "server.log.1")
+		if err := os.Rename(file, moved); err != nil {
+			t.Fatalf("failed to move log file: %v", err)
+		}
+		f.signals <- os.Interrupt
+		waitFor(t, "log file to be reopened", func() bool {
+			writeLine(t, f, "after move")
+			_, err := os.Stat(file)
+			return err == nil
+		})
+		content, err := os.ReadFile(moved)
+		if err != nil {
+			t.Fatalf("failed to read moved log file: %v", err)
+		}
+		if string(content[:len("before move\n")]) != "before move\n" {
+			t.Errorf("unexpected moved log file content, got %q", content)
+		}
+	})
+}
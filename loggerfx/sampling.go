@@ -0,0 +1,143 @@
+package loggerfx
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// SamplingConfig keeps the first Initial entries with the same level and
+// message in every Tick, then every Thereafter-th entry.
+type SamplingConfig struct {
+	Initial    int           `mapstructure:"initial" yaml:"initial" validate:"min=1"`
+	Thereafter int           `mapstructure:"thereafter" yaml:"thereafter" validate:"min=0"`
+	Tick       time.Duration `mapstructure:"tick" yaml:"tick" validate:"required"`
+}
+
+// RateLimitConfig limits the entries with the same level and message to Burst
+// in every Interval across all sinks. The number of dropped entries is logged
+// at the end of the interval.
+type RateLimitConfig struct {
+	Enabled  bool          `mapstructure:"enabled" yaml:"enabled"`
+	Burst    int           `mapstructure:"burst" yaml:"burst" validate:"min=1"`
+	Interval time.Duration `mapstructure:"interval" yaml:"interval" validate:"required"`
+}
+
+// newSampler wraps the core of a sink to drop the entries over its sampling
+// config.
+func newSampler(core zapcore.Core, config SamplingConfig) zapcore.Core {
+	return zapcore.NewSamplerWithOptions(core, config.Tick, config.Initial, config.Thereafter)
+}
+
+type rateLimitKey struct {
+	level   zapcore.Level
+	message string
+}
+
+// rateLimiter counts the entries of the current interval, shared by the
+// cores derived from the root core with With().
+type rateLimiter struct {
+	mu         sync.Mutex
+	config     RateLimitConfig
+	core       zapcore.Core
+	counts     map[rateLimitKey]int
+	suppressed map[rateLimitKey]int
+}
+
+func newRateLimiter(lifecycle fx.Lifecycle, core zapcore.Core, config RateLimitConfig) *rateLimiter {
+	limiter := &rateLimiter{
+		config:     config,
+		core:       core,
+		counts:     map[rateLimitKey]int{},
+		suppressed: map[rateLimitKey]int{},
+	}
+	ticker := time.NewTicker(config.Interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(stopped)
+				for {
+					select {
+					case <-ticker.C:
+						limiter.flush()
+					case <-done:
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			ticker.Stop()
+			close(done)
+			<-stopped
+			limiter.flush()
+			return nil
+		},
+	})
+	return limiter
+}
+
+func (l *rateLimiter) allow(entry zapcore.Entry) bool {
+	key := rateLimitKey{entry.Level, entry.Message}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts[key] < l.config.Burst {
+		l.counts[key]++
+		return true
+	}
+	l.suppressed[key]++
+	return false
+}
+
+// flush logs a summary of the suppressed entries and starts a new interval.
+func (l *rateLimiter) flush() {
+	l.mu.Lock()
+	suppressed := l.suppressed
+	l.counts = map[rateLimitKey]int{}
+	l.suppressed = map[rateLimitKey]int{}
+	l.mu.Unlock()
+
+	for key, count := range suppressed {
+		entry := zapcore.Entry{
+			Level:   key.level,
+			Time:    time.Now(),
+			Message: fmt.Sprintf("%d messages suppressed", count),
+		}
+		if checked := l.core.Check(entry, nil); checked != nil {
+			checked.Write(
+				zap.String("suppressed_msg", key.message),
+				zap.Int("suppressed", count),
+				zap.Duration("interval", l.config.Interval),
+			)
+		}
+	}
+}
+
+// rateLimitCore drops the entries over the limit before they reach the sinks.
+type rateLimitCore struct {
+	zapcore.Core
+	limiter *rateLimiter
+}
+
+func (c *rateLimitCore) With(fields []zapcore.Field) zapcore.Core {
+	return &rateLimitCore{Core: c.Core.With(fields), limiter: c.limiter}
+}
+
+func (c *rateLimitCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Core.Enabled(entry.Level) {
+		return checked
+	}
+	// entries which terminate the process are never dropped
+	if entry.Level < zapcore.DPanicLevel && !c.limiter.allow(entry) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
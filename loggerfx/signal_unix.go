@@ -0,0 +1,14 @@
+//go:build !windows
+
+package loggerfx
+
+import (
+	"os"
+	"syscall"
+)
+
+// debugSignal temporarily lowers the log levels to debug.
+var debugSignal os.Signal = syscall.SIGUSR1
+
+// reopenSignal reopens the log file after it is moved by logrotate.
+var reopenSignal os.Signal = syscall.SIGHUP
@@ -0,0 +1,11 @@
+//go:build windows
+
+package loggerfx
+
+import "os"
+
+// debugSignal is not available on windows, which has no SIGUSR1.
+var debugSignal os.Signal
+
+// reopenSignal is not available on windows, where open files cannot be moved.
+var reopenSignal os.Signal
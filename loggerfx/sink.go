@@ -0,0 +1,238 @@
+package loggerfx
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/astaclinic/astafx/logger"
+)
+
+// LogSink is an output of the application logger. Besides the file and the
+// console sinks configured by LoggerConfig, sinks are contributed by other
+// modules through the "logSinks" group, see AsLogSink.
+type LogSink interface {
+	// Name identifies the sink, its level is changed through Levels by name
+	Name() string
+	// Level is the initial level of the sink
+	Level() zapcore.Level
+	// Core returns the core writing the entries of the sink, which must only
+	// write the entries enabled by level
+	Core(level zapcore.LevelEnabler) (zapcore.Core, error)
+}
+
+// AsLogSink annotates a constructor of a LogSink to add the sink to the
+// application logger.
+func AsLogSink(sink any) any {
+	return fx.Annotate(
+		sink,
+		fx.As(new(LogSink)),
+		fx.ResultTags(`group:"logSinks"`),
+	)
+}
+
+// WriterSink writes the entries encoded by its encoder to a writer, such as a
+// net.Conn shipping JSON lines or a syslog writer.
+type WriterSink struct {
+	name    string
+	level   zapcore.Level
+	encoder zapcore.Encoder
+	writer  zapcore.WriteSyncer
+}
+
+func NewWriterSink(name string, level zapcore.Level, encoder zapcore.Encoder, writer zapcore.WriteSyncer) *WriterSink {
+	return &WriterSink{
+		name:    name,
+		level:   level,
+		encoder: encoder,
+		writer:  writer,
+	}
+}
+
+func (s *WriterSink) Name() string {
+	return s.name
+}
+
+func (s *WriterSink) Level() zapcore.Level {
+	return s.level
+}
+
+func (s *WriterSink) Core(level zapcore.LevelEnabler) (zapcore.Core, error) {
+	// the writer is locked as it is shared by every copy of the core
+	return zapcore.NewCore(s.encoder, zapcore.Lock(s.writer), level), nil
+}
+
+// RingBufferSink keeps the last log entries in memory as JSON lines.
+type RingBufferSink struct {
+	name  string
+	level zapcore.Level
+	mu    sync.Mutex
+	lines [][]byte
+	next  int
+	full  bool
+}
+
+func NewRingBufferSink(name string, level zapcore.Level, size int) *RingBufferSink {
+	return &RingBufferSink{
+		name:  name,
+		level: level,
+		lines: make([][]byte, size),
+	}
+}
+
+func (s *RingBufferSink) Name() string {
+	return s.name
+}
+
+func (s *RingBufferSink) Level() zapcore.Level {
+	return s.level
+}
+
+func (s *RingBufferSink) Core(level zapcore.LevelEnabler) (zapcore.Core, error) {
+	encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	return zapcore.NewCore(encoder, zapcore.AddSync(s), level), nil
+}
+
+// Write stores a single encoded entry, the core writes one entry per call.
+func (s *RingBufferSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.lines) == 0 {
+		return len(p), nil
+	}
+	s.lines[s.next] = append([]byte{}, p...)
+	s.next = (s.next + 1) % len(s.lines)
+	if s.next == 0 {
+		s.full = true
+	}
+	return len(p), nil
+}
+
+// Lines returns the buffered entries from the oldest to the newest.
+func (s *RingBufferSink) Lines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var lines []string
+	if s.full {
+		for _, line := range s.lines[s.next:] {
+			lines = append(lines, string(line))
+		}
+	}
+	for _, line := range s.lines[:s.next] {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// fileSink writes JSON lines to the rotated log file.
+type fileSink struct {
+	config *LoggerConfig
+	file   *rotatingFile
+}
+
+func newFileSink(lifecycle fx.Lifecycle, config *LoggerConfig) (*fileSink, error) {
+	// create directory if needed
+	err := os.MkdirAll(config.Path, os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("error in creating log file folder for writing: %w", err)
+	}
+
+	// create a new writer for log rotation
+	file := newRotatingFile(config.Path, config.File)
+	lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			file.start()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			return file.shutdown()
+		},
+	})
+	return &fileSink{config, file}, nil
+}
+
+func (s *fileSink) Name() string {
+	return fileOutput
+}
+
+func (s *fileSink) Level() zapcore.Level {
+	return logLevelMap[s.config.Level.File]
+}
+
+func (s *fileSink) Core(level zapcore.LevelEnabler) (zapcore.Core, error) {
+	encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	return zapcore.NewCore(encoder, zapcore.AddSync(s.file), level), nil
+}
+
+type ConsoleEncodingType string
+
+var (
+	// ConsoleEncoding writes human readable lines
+	ConsoleEncoding ConsoleEncodingType = "console"
+	// JSONEncoding writes JSON lines, for the log collector of a container runtime
+	JSONEncoding ConsoleEncodingType = "json"
+)
+
+type ColorMode string
+
+var (
+	// ColorAuto colors the levels if the output is a terminal and the
+	// NO_COLOR environment variable is not set
+	ColorAuto   ColorMode = "auto"
+	ColorAlways ColorMode = "always"
+	ColorNever  ColorMode = "never"
+)
+
+type ConsoleOutputType string
+
+var (
+	StdoutOutput ConsoleOutputType = "stdout"
+	StderrOutput ConsoleOutputType = "stderr"
+)
+
+type ConsoleConfig struct {
+	Encoding ConsoleEncodingType `mapstructure:"encoding" yaml:"encoding" validate:"required,oneof=console json"`
+	Color    ColorMode           `mapstructure:"color" yaml:"color" validate:"required,oneof=auto always never"`
+	Output   ConsoleOutputType   `mapstructure:"output" yaml:"output" validate:"required,oneof=stdout stderr"`
+}
+
+// consoleSink writes to stdout or stderr.
+type consoleSink struct {
+	config *LoggerConfig
+}
+
+func (s *consoleSink) Name() string {
+	return consoleOutput
+}
+
+func (s *consoleSink) Level() zapcore.Level {
+	return logLevelMap[s.config.Level.Console]
+}
+
+func (s *consoleSink) Core(level zapcore.LevelEnabler) (zapcore.Core, error) {
+	output := os.Stdout
+	if s.config.Console.Output == StderrOutput {
+		output = os.Stderr
+	}
+	// when writing to a file, the *os.File need to be locked with Lock() for concurrent access
+	writer := zapcore.Lock(output)
+
+	if s.config.Console.Encoding == JSONEncoding {
+		encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+		return zapcore.NewCore(encoder, writer, level), nil
+	}
+
+	var colored bool
+	switch s.config.Console.Color {
+	case ColorAlways:
+		colored = true
+	case ColorAuto:
+		colored = logger.IsColorTerminal(output)
+	}
+	return zapcore.NewCore(logger.NewConsoleEncoder(colored), writer, level), nil
+}
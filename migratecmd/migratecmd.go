@@ -0,0 +1,103 @@
+package migratecmd
+
+import (
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/fx"
+
+	"github.com/astaclinic/astafx/dbfx"
+	"github.com/astaclinic/astafx/logger"
+)
+
+// New returns the "migrate" command with the up, down and status
+// subcommands, to be mounted on the root command of a binary. The options
+// build the *dbfx.Migrator as in the app, e.g. configfx.Module,
+// loggerfx.Module, dbfx.Module and the migration sources; the app is not
+// started.
+func New(opts ...fx.Option) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply and revert the database migrations",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// keep the output of the subcommands free of log lines
+			logger.Output = cmd.ErrOrStderr()
+		},
+	}
+
+	withMigrator := func(fn func(cmd *cobra.Command, migrator *dbfx.Migrator) error) func(cmd *cobra.Command, args []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			var migrator *dbfx.Migrator
+			app := fx.New(
+				fx.Options(opts...),
+				fx.Populate(&migrator),
+				// after the options, as the last logger wins over the one of
+				// loggerfx
+				fx.NopLogger,
+			)
+			if err := app.Err(); err != nil {
+				return err
+			}
+			return fn(cmd, migrator)
+		}
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:          "up",
+			Short:        "Apply the pending migrations",
+			Args:         cobra.NoArgs,
+			SilenceUsage: true,
+			RunE: withMigrator(func(cmd *cobra.Command, migrator *dbfx.Migrator) error {
+				return migrator.Up(cmd.Context())
+			}),
+		},
+		&cobra.Command{
+			Use:          "down [steps]",
+			Short:        "Revert the latest applied migrations, one by default",
+			Args:         cobra.MaximumNArgs(1),
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				steps := 1
+				if len(args) > 0 {
+					var err error
+					if steps, err = strconv.Atoi(args[0]); err != nil || steps < 1 {
+						return fmt.Errorf("invalid steps %q", args[0])
+					}
+				}
+				return withMigrator(func(cmd *cobra.Command, migrator *dbfx.Migrator) error {
+					return migrator.Down(cmd.Context(), steps)
+				})(cmd, args)
+			},
+		},
+		&cobra.Command{
+			Use:          "status",
+			Short:        "Print the migrations and whether they are applied",
+			Args:         cobra.NoArgs,
+			SilenceUsage: true,
+			RunE: withMigrator(func(cmd *cobra.Command, migrator *dbfx.Migrator) error {
+				statuses, err := migrator.Status(cmd.Context())
+				if err != nil {
+					return err
+				}
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "VERSION\tNAME\tSOURCE\tAPPLIED AT")
+				for _, status := range statuses {
+					appliedAt := "pending"
+					if status.AppliedAt != nil {
+						appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+					}
+					source := status.Source
+					if status.Missing {
+						source = "(missing)"
+					}
+					fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Name, source, appliedAt)
+				}
+				return w.Flush()
+			}),
+		},
+	)
+	return cmd
+}
@@ -3,27 +3,48 @@ package mongofx
 import (
 	"context"
 
-	"github.com/spf13/viper"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/astaclinic/astafx/configfx"
+	"github.com/astaclinic/astafx/healthfx"
+	"github.com/astaclinic/astafx/retry"
 )
 
 type MongoConfig struct {
-	Dsn string `mapstructure:"dsn" yaml:"dsn" validate:"required,uri"`
+	Dsn string `mapstructure:"dsn" yaml:"dsn" validate:"required,uri" secret:"true"`
+	// Retry is the policy of the connection at startup
+	Retry retry.Policy `mapstructure:"retry" yaml:"retry"`
 }
 
-func init() {
-	// config must have a default value for viper to load config from env variables
-	// default value of empty string (zero value) will not pass the "required" config validation
-	viper.SetDefault("mongo.dsn", "")
+type Params struct {
+	fx.In
+	Lifecycle fx.Lifecycle
+	Config    *MongoConfig
+	Logger    *zap.SugaredLogger
 }
 
-func NewMongoClient(config *MongoConfig) (*mongo.Client, error) {
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(config.Dsn))
+func NewMongoClient(p Params) (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(p.Config.Dsn))
 	if err != nil {
 		return nil, err
 	}
+	// the client connects in the background, so the connection is verified
+	// by a ping on start, bounded by the start timeout of the app
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			if err := p.Config.Retry.Do(ctx, p.Logger, "mongo", func(ctx context.Context) error {
+				return client.Ping(ctx, readpref.Primary())
+			}); err != nil {
+				client.Disconnect(context.Background())
+				return err
+			}
+			return nil
+		},
+	})
 	return client, nil
 }
 
@@ -35,7 +56,18 @@ func CleanupMongoClient(lifecycle fx.Lifecycle, client *mongo.Client) {
 	})
 }
 
+// NewHealthChecker checks the connection to the primary of mongo.
+func NewHealthChecker(client *mongo.Client) *healthfx.FuncChecker {
+	return healthfx.NewChecker("mongo", func(ctx context.Context) error {
+		return client.Ping(ctx, readpref.Primary())
+	})
+}
+
 var Module = fx.Options(
+	configfx.Provide("mongo", MongoConfig{
+		Retry: retry.DefaultPolicy(),
+	}),
 	fx.Provide(NewMongoClient),
 	fx.Invoke(CleanupMongoClient),
+	fx.Provide(healthfx.AsChecker(NewHealthChecker)),
 )
@@ -4,36 +4,61 @@ import (
 	"context"
 
 	"github.com/go-redis/redis/v9"
-	"github.com/spf13/viper"
 	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/astaclinic/astafx/configfx"
+	"github.com/astaclinic/astafx/healthfx"
+	"github.com/astaclinic/astafx/retry"
 )
 
 var Module = fx.Module("redis",
+	configfx.Provide("redis", RedisConfig{
+		Retry: retry.DefaultPolicy(),
+	}),
 	fx.Provide(New),
+	fx.Provide(healthfx.AsChecker(NewHealthChecker)),
 )
 
 type RedisConfig struct {
 	Dsn      string `mapstructure:"dsn" yaml:"dsn" validate:"required,hostname_port"`
-	Password string `mapstructure:"password" yaml:"password" validate:"printascii"`
+	Password string `mapstructure:"password" yaml:"password" validate:"printascii" secret:"true"`
+	// Retry is the policy of the connection at startup
+	Retry retry.Policy `mapstructure:"retry" yaml:"retry"`
 }
 
-func init() {
-	// config must have a default value for viper to load config from env variables
-	// default value of empty string (zero value) will not pass the "required" config validation
-	viper.SetDefault("redis.dsn", "")
-	viper.SetDefault("redis.password", "")
+type Params struct {
+	fx.In
+	Lifecycle fx.Lifecycle
+	Config    *RedisConfig
+	Logger    *zap.SugaredLogger
 }
 
-func New(config *RedisConfig) (*redis.Client, error) {
-	ctx := context.Background()
+func New(p Params) *redis.Client {
 	client := redis.NewClient(&redis.Options{
-		Addr:     config.Dsn,
-		Password: config.Password, // no password set
-		DB:       0,               // use default DB
+		Addr:     p.Config.Dsn,
+		Password: p.Config.Password, // no password set
+		DB:       0,                 // use default DB
+	})
+	// the connection is verified on start, bounded by the start timeout of
+	// the app
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			if err := p.Config.Retry.Do(ctx, p.Logger, "redis", func(ctx context.Context) error {
+				return client.Ping(ctx).Err()
+			}); err != nil {
+				client.Close()
+				return err
+			}
+			return nil
+		},
+	})
+	return client
+}
+
+// NewHealthChecker checks the connection to redis by PING.
+func NewHealthChecker(client *redis.Client) *healthfx.FuncChecker {
+	return healthfx.NewChecker("redis", func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
 	})
-	_, err := client.Ping(ctx).Result()
-	if err != nil {
-		return nil, err
-	}
-	return client, nil
 }
@@ -0,0 +1,89 @@
+// Package retry retries the connections of the datastore modules at startup,
+// so the app waits for a datastore started at the same time. The modules
+// connect in their OnStart hooks, so the retries are bounded by the start
+// timeout of the app.
+package retry
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Policy retries with exponential backoff and full jitter, until the maximum
+// number of attempts, the deadline or the cancellation of the context.
+type Policy struct {
+	MaxAttempts    int           `mapstructure:"max_attempts" yaml:"max_attempts" validate:"min=1"`
+	InitialBackoff time.Duration `mapstructure:"initial_backoff" yaml:"initial_backoff" validate:"required"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff" yaml:"max_backoff" validate:"required,gtefield=InitialBackoff"`
+	// Deadline bounds all the attempts within the context, 0 leaves them
+	// bounded by the context only, e.g. the start timeout of the app
+	Deadline time.Duration `mapstructure:"deadline" yaml:"deadline" validate:"min=0"`
+	// AttemptTimeout bounds every attempt, so an unreachable host leaves time
+	// for the retries, 0 leaves them bounded by the deadline only
+	AttemptTimeout time.Duration `mapstructure:"attempt_timeout" yaml:"attempt_timeout" validate:"min=0"`
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:    5,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		AttemptTimeout: 2 * time.Second,
+	}
+}
+
+// Backoff returns the delay before the retry after attempt, counted from 0,
+// growing exponentially from initial up to max with full jitter.
+func Backoff(initial, max time.Duration, attempt int) time.Duration {
+	backoff := initial << attempt
+	if backoff <= 0 || backoff > max {
+		backoff = max
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// Do calls fn until it succeeds, logging every failed attempt. The context
+// passed to fn is canceled at the attempt timeout or the deadline.
+func (p Policy) Do(ctx context.Context, logger *zap.SugaredLogger, target string, fn func(ctx context.Context) error) error {
+	if p.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Deadline)
+		defer cancel()
+	}
+	for attempt := 1; ; attempt++ {
+		err := p.attempt(ctx, fn)
+		if err == nil {
+			if attempt > 1 {
+				logger.Infow("connected", "target", target, "attempt", attempt)
+			}
+			return nil
+		}
+		if attempt >= p.MaxAttempts {
+			return fmt.Errorf("fail to connect to %s after %d attempts: %w", target, attempt, err)
+		}
+		backoff := Backoff(p.InitialBackoff, p.MaxBackoff, attempt-1)
+		logger.Warnw("fail to connect, retrying",
+			"target", target, "attempt", attempt, "max_attempts", p.MaxAttempts, "backoff", backoff, "err", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("fail to connect to %s after %d attempts, %v: %w", target, attempt, ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt calls fn with the context bounded by the attempt timeout.
+func (p Policy) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if p.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.AttemptTimeout)
+		defer cancel()
+	}
+	return fn(ctx)
+}
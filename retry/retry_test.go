@@ -0,0 +1,98 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestDo(t *testing.T) {
+	policy := Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Deadline: time.Second}
+	t.Run("Test success after retries", func(t *testing.T) {
+		attempts := 0
+		err := policy.Do(context.Background(), zap.NewNop().Sugar(), "test", func(context.Context) error {
+			attempts++
+			if attempts < 3 {
+				return errors.New("connection refused")
+			}
+			return nil
+		})
+		if err != nil || attempts != 3 {
+			t.Errorf("unexpected result, got %v after %d attempts", err, attempts)
+		}
+	})
+	t.Run("Test max attempts", func(t *testing.T) {
+		attempts := 0
+		refused := errors.New("connection refused")
+		err := policy.Do(context.Background(), zap.NewNop().Sugar(), "test", func(context.Context) error {
+			attempts++
+			return refused
+		})
+		if !errors.Is(err, refused) || attempts != 3 {
+			t.Errorf("unexpected result, got %v after %d attempts", err, attempts)
+		}
+	})
+	t.Run("Test context", func(t *testing.T) {
+		policy := Policy{MaxAttempts: 100, InitialBackoff: 20 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err := policy.Do(ctx, zap.NewNop().Sugar(), "test", func(context.Context) error {
+			return errors.New("connection refused")
+		})
+		if err == nil || time.Since(start) > time.Second {
+			t.Errorf("unexpected result, got %v after %v", err, time.Since(start))
+		}
+	})
+	t.Run("Test attempt timeout", func(t *testing.T) {
+		policy := Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, AttemptTimeout: 10 * time.Millisecond}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		attempts := 0
+		// an unreachable host blocks until the context is done
+		err := policy.Do(ctx, zap.NewNop().Sugar(), "test", func(ctx context.Context) error {
+			attempts++
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		if !errors.Is(err, context.DeadlineExceeded) || attempts != 3 || ctx.Err() != nil {
+			t.Errorf("unexpected result, got %v after %d attempts", err, attempts)
+		}
+	})
+	t.Run("Test deadline", func(t *testing.T) {
+		policy := Policy{MaxAttempts: 100, InitialBackoff: 20 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, Deadline: 50 * time.Millisecond}
+		start := time.Now()
+		err := policy.Do(context.Background(), zap.NewNop().Sugar(), "test", func(context.Context) error {
+			return errors.New("connection refused")
+		})
+		if err == nil || time.Since(start) > time.Second {
+			t.Errorf("unexpected result, got %v after %v", err, time.Since(start))
+		}
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	t.Run("Test growth and cap", func(t *testing.T) {
+		for attempt, max := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond} {
+			if got := Backoff(10*time.Millisecond, 50*time.Millisecond, attempt); got < 0 || got > max {
+				t.Errorf("unexpected backoff of attempt %d, got %v, expected at most %v", attempt, got, max)
+			}
+		}
+		// the shift overflows on late attempts, which are capped too
+		if got := Backoff(time.Second, time.Minute, 100); got < 0 || got > time.Minute {
+			t.Errorf("unexpected backoff of late attempt, got %v, expected at most %v", got, time.Minute)
+		}
+	})
+	t.Run("Test jitter", func(t *testing.T) {
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 100; i++ {
+			seen[Backoff(time.Second, time.Second, 0)] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("unexpected backoff without jitter, got %v", seen)
+		}
+	})
+}
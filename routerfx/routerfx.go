@@ -1,13 +1,18 @@
 package routerfx
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strings"
 	"time"
 
 	ginzap "github.com/gin-contrib/zap"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/astaclinic/astafx/logger"
 )
 
 var Module = fx.Module("router",
@@ -30,8 +35,18 @@ func New(p Params) Result {
 	gin.SetMode(gin.ReleaseMode)
 
 	http := gin.New()
+	// lookup of values on *gin.Context falls back to the request context,
+	// so the context logger is available from handlers by FromContext(c)
+	http.ContextWithFallback = true
 	if p.Logger != nil {
-		http.Use(ginzap.Ginzap(p.Logger.Desugar(), time.RFC3339, true))
+		http.Use(requestLogger(p.Logger))
+		http.Use(ginzap.GinzapWithConfig(p.Logger.Desugar(), &ginzap.Config{
+			TimeFormat: time.RFC3339,
+			UTC:        true,
+			Context: func(c *gin.Context) []zapcore.Field {
+				return []zapcore.Field{zap.String("request_id", c.Writer.Header().Get(RequestIDHeader))}
+			},
+		}))
 	}
 	http.Use(gin.Recovery())
 
@@ -57,6 +72,25 @@ func New(p Params) Result {
 	}
 }
 
+// RequestIDHeader is the header of the request ID, which is generated for
+// requests without one and returned in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// requestLogger adds a logger with the request ID, route and peer fields to
+// the request context.
+func requestLogger(base *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" {
+			id = logger.NewRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+		requestLogger := base.With("request_id", id, "route", c.FullPath(), "peer", c.ClientIP())
+		c.Request = c.Request.WithContext(logger.NewContext(c.Request.Context(), requestLogger))
+		c.Next()
+	}
+}
+
 func (r *Result) GetHttpRouter() *gin.Engine {
 	return r.Http
 }
@@ -79,6 +113,19 @@ type HandlerRoute interface {
 	RoutePattern() string
 }
 
+// AuthorizeBearer reports whether r carries token as a bearer token, writing
+// the 401 response if not. The token must not be empty.
+func AuthorizeBearer(w http.ResponseWriter, r *http.Request, token string) bool {
+	authorization := r.Header.Get("Authorization")
+	given := strings.TrimPrefix(authorization, "Bearer ")
+	if given == authorization || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
 func AsHandlerRoute(handler any) any {
 	return fx.Annotate(
 		handler,
@@ -0,0 +1,64 @@
+package routerfx_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/astaclinic/astafx/logger"
+	"github.com/astaclinic/astafx/routerfx"
+)
+
+type logHandler struct{}
+
+func (logHandler) HttpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context(), zap.NewNop().Sugar()).Info("handled")
+	})
+}
+
+func (logHandler) RoutePattern() string {
+	return "/log"
+}
+
+func TestRequestLogger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	result := routerfx.New(routerfx.Params{
+		Logger:        zap.New(core).Sugar(),
+		HandlerRoutes: []routerfx.HandlerRoute{logHandler{}},
+	})
+
+	t.Run("Test given request ID", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/log", nil)
+		req.Header.Set(routerfx.RequestIDHeader, "abc")
+		rec := httptest.NewRecorder()
+		result.Http.ServeHTTP(rec, req)
+		if got := rec.Header().Get(routerfx.RequestIDHeader); got != "abc" {
+			t.Errorf("unexpected request ID header, got %q, expected abc", got)
+		}
+		entries := logs.FilterMessage("handled").TakeAll()
+		if len(entries) != 1 {
+			t.Fatalf("unexpected entries of the handler, got %v", entries)
+		}
+		fields := entries[0].ContextMap()
+		if fields["request_id"] != "abc" || fields["route"] != "/log" {
+			t.Errorf("unexpected fields of the request logger, got %v", fields)
+		}
+	})
+	t.Run("Test generated request ID", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		result.Http.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/log", nil))
+		id := rec.Header().Get(routerfx.RequestIDHeader)
+		if len(id) != 32 {
+			t.Errorf("unexpected generated request ID, got %q", id)
+		}
+		entries := logs.FilterField(zap.String("request_id", id)).TakeAll()
+		// the entries of the handler and of the access log
+		if len(entries) != 2 {
+			t.Errorf("unexpected entries with the request ID, got %v", entries)
+		}
+	})
+}
@@ -5,8 +5,9 @@ import (
 	"time"
 
 	"github.com/getsentry/sentry-go"
-	"github.com/spf13/viper"
 	"go.uber.org/fx"
+
+	"github.com/astaclinic/astafx/configfx"
 )
 
 var (
@@ -15,16 +16,10 @@ var (
 )
 
 type SentryConfig struct {
-	Dsn   string `mapstructure:"dsn" yaml:"dsn" validate:"required,uri"`
+	Dsn   string `mapstructure:"dsn" yaml:"dsn" validate:"required,uri" secret:"true"`
 	Debug bool   `mapstructure:"debug" yaml:"debug"`
 }
 
-func init() {
-	// config must have a default value for viper to load config from env variables
-	// default value of empty string (zero value) will not pass the "required" config validation
-	viper.SetDefault("sentry.dsn", "")
-}
-
 func RunSentry(lifecycle fx.Lifecycle, config *SentryConfig) {
 	lifecycle.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
@@ -42,6 +37,14 @@ func RunSentry(lifecycle fx.Lifecycle, config *SentryConfig) {
 	})
 }
 
+// NewHub returns the hub of the app, whose client is bound by RunSentry on
+// start.
+func NewHub() *sentry.Hub {
+	return sentry.CurrentHub()
+}
+
 var Module = fx.Module("sentry",
+	configfx.Provide("sentry", SentryConfig{}),
+	fx.Provide(NewHub),
 	fx.Invoke(RunSentry),
 )